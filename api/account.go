@@ -0,0 +1,80 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Username returns the name of the authenticated user.
+// It is either the username from the credentials, or is requested from /api/v1/me once, and then cached.
+func (c *Client) Username(ctx context.Context) (string, error) {
+	if c.auth == nil {
+		return "", fmt.Errorf("client has no credentials")
+	}
+
+	c.auth.mu.Lock()
+	username := c.auth.username
+	if username == "" {
+		username = c.auth.creds.Username
+	}
+	c.auth.mu.Unlock()
+	if username != "" {
+		return username, nil
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, c.oauthbase.JoinPath("api", "v1", "me").String(), http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	res, err := c.do(req, true)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if err := checkResponse(res, jsonContentTypes); err != nil {
+		return "", err
+	}
+	var me struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&me); err != nil {
+		return "", err
+	}
+	if me.Name == "" {
+		return "", fmt.Errorf("the token does not belong to a user, app-only access can't be used")
+	}
+
+	c.auth.mu.Lock()
+	c.auth.username = me.Name
+	c.auth.mu.Unlock()
+
+	return me.Name, nil
+}
+
+// Unsave removes the post (or comment) with the given name (e.g. t3_11tug3p) from the saved list of the user.
+func (c *Client) Unsave(ctx context.Context, name string) error {
+	if c.auth == nil {
+		return fmt.Errorf("client has no credentials")
+	}
+
+	form := url.Values{"id": {name}}
+	req, err := c.newRequest(ctx, http.MethodPost, c.oauthbase.JoinPath("api", "unsave").String(),
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := c.do(req, true)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return checkResponse(res, jsonContentTypes)
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsernameAndUnsave(t *testing.T) {
+	t.Parallel()
+	var (
+		me      atomic.Int32
+		unsaved atomic.Value
+		mux     = http.NewServeMux()
+		respond = func(w http.ResponseWriter, body string) {
+			w.Header().Set("Content-Type", "application/json")
+			_, err := w.Write([]byte(body))
+			assert.NoError(t, err)
+		}
+	)
+	mux.HandleFunc(accessTokenPath, func(w http.ResponseWriter, r *http.Request) {
+		respond(w, `{"access_token": "token", "token_type": "bearer", "expires_in": 3600}`)
+	})
+	mux.HandleFunc("/api/v1/me", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "bearer token", r.Header.Get("Authorization"))
+		me.Add(1)
+		respond(w, `{"name": "example"}`)
+	})
+	mux.HandleFunc("/api/unsave", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "bearer token", r.Header.Get("Authorization"))
+		assert.NoError(t, r.ParseForm())
+		unsaved.Store(r.PostForm.Get("id"))
+		respond(w, `{}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	c := DefaultClient().WithAuthURL(u).WithOAuthURL(u).WithCredentials(Credentials{ClientID: "id", RefreshToken: "refresh"})
+
+	for i := 0; i < 2; i++ {
+		name, err := c.Username(context.TODO())
+		assert.NoError(t, err)
+		assert.Equal(t, "example", name)
+	}
+	assert.Equal(t, int32(1), me.Load(), "username should be cached")
+
+	assert.NoError(t, c.Unsave(context.TODO(), "t3_11tug3p"))
+	assert.Equal(t, "t3_11tug3p", unsaved.Load())
+
+	_, err = DefaultClient().Username(context.TODO())
+	assert.Error(t, err, "anonymous client has no username")
+}
+
+func TestSavedURLFormatting(t *testing.T) {
+	t.Parallel()
+	opts := &RequestOptions{Count: 100, Sorting: "new", User: "example", UserListing: "saved", Kind: "links"}
+	assert.Equal(t, "https://reddit.com/user/example/saved.json?after=&limit=100&sort=new&t=&type=links",
+		DefaultClient().optsURL(opts), "incorrect url format")
+}
+
+func TestComment(t *testing.T) {
+	t.Parallel()
+	var p Post
+	p.Kind = "t1"
+	p.Data.LinkURL = "https://i.redd.it/05sk8tzriboa1.png"
+	p.Data.LinkTitle = "Staring into the woods"
+	assert.True(t, p.IsComment())
+	assert.Equal(t, "image", p.Type(), "comment type should come from its post")
+	assert.Equal(t, p.Data.LinkURL, p.URL())
+	assert.Equal(t, p.Data.LinkTitle, p.Title())
+}
@@ -4,7 +4,11 @@
 package api
 
 import (
+	"math"
+	"net/url"
+	"path"
 	"strings"
+	"time"
 )
 
 type Posts struct {
@@ -15,26 +19,105 @@ type Posts struct {
 }
 
 type Post struct {
-	Data struct {
-		Media struct {
-			RedditVideo *Video `json:"reddit_video"`
-		} `json:"media"`
-		Title     string `json:"title"`
-		URL       string `json:"url"`
-		PostHint  string `json:"post_hint"`
-		Subreddit string `json:"subreddit"`
-		Preview   struct {
-			Images []Image `json:"images"`
-		}
-		Over18  bool `json:"over_18"`
-		IsVideo bool `json:"is_video"`
-	} `json:"data"`
+	// Kind is "t3" for posts, saved and upvoted listings may also contain comments ("t1").
+	Kind string   `json:"kind"`
+	Data PostData `json:"data"`
+}
+
+// PostData is the data of a post in a listing.
+type PostData struct {
+	Media struct {
+		RedditVideo *Video `json:"reddit_video"`
+	} `json:"media"`
+	Title     string `json:"title"`
+	URL       string `json:"url"`
+	PostHint  string `json:"post_hint"`
+	Subreddit string `json:"subreddit"`
+	Preview   struct {
+		Images []Image `json:"images"`
+	}
+	MediaMetadata map[string]MediaMetadata `json:"media_metadata"`
+	GalleryData   *GalleryData             `json:"gallery_data"`
+	// CrosspostParentList contains the original post of a crosspost, which has the media.
+	CrosspostParentList []PostData `json:"crosspost_parent_list"`
+	// CrosspostParent is the name of the original post of a crosspost.
+	CrosspostParent string `json:"crosspost_parent"`
+	// LinkURL and LinkTitle describe the post of a comment.
+	LinkURL   string `json:"link_url"`
+	LinkTitle string `json:"link_title"`
+	// ID is the post id, e.g. 11tug3p, and Name is its fullname, e.g. t3_11tug3p.
+	ID          string  `json:"id"`
+	Name        string  `json:"name"`
+	Author      string  `json:"author"`
+	Score       int     `json:"score"`
+	Ups         int     `json:"ups"`
+	UpvoteRatio float64 `json:"upvote_ratio"`
+	NumComments int     `json:"num_comments"`
+	// CreatedUTC is the unix time of the post creation.
+	CreatedUTC    float64 `json:"created_utc"`
+	LinkFlairText string  `json:"link_flair_text"`
+	// Permalink is the path of the post page, e.g. /r/wallpaper/comments/11tug3p/title/.
+	Permalink string `json:"permalink"`
+	// Domain is the domain of the post url, e.g. i.redd.it, or self.{subreddit} for text posts.
+	Domain    string `json:"domain"`
+	Over18    bool   `json:"over_18"`
+	Spoiler   bool   `json:"spoiler"`
+	Stickied  bool   `json:"stickied"`
+	IsVideo   bool   `json:"is_video"`
+	IsGallery bool   `json:"is_gallery"`
 }
 
 type Video struct {
 	ScrubberMediaURL string `json:"scrubber_media_url"`
-	Height           int    `json:"height"`
-	Width            int    `json:"width"`
+	// FallbackURL is the video without audio, in the highest resolution.
+	FallbackURL string `json:"fallback_url"`
+	// DashURL is the DASH manifest listing the video and audio tracks.
+	DashURL  string `json:"dash_url"`
+	HLSURL   string `json:"hls_url"`
+	Height   int    `json:"height"`
+	Width    int    `json:"width"`
+	HasAudio bool   `json:"has_audio"`
+}
+
+// GalleryData is the order of the media in a gallery post.
+type GalleryData struct {
+	Items []struct {
+		MediaID string `json:"media_id"`
+		Caption string `json:"caption"`
+		ID      int    `json:"id"`
+	} `json:"items"`
+}
+
+// MediaMetadata describes a single media of a gallery post.
+type MediaMetadata struct {
+	Status string `json:"status"`
+	// Kind is either "Image" or "AnimatedImage".
+	Kind   string `json:"e"`
+	MIME   string `json:"m"`
+	ID     string `json:"id"`
+	Source struct {
+		URL    string `json:"u"`
+		GIF    string `json:"gif"`
+		MP4    string `json:"mp4"`
+		Width  int    `json:"x"`
+		Height int    `json:"y"`
+	} `json:"s"`
+}
+
+// GalleryImage is a single media of a gallery post.
+type GalleryImage struct {
+	MediaID string
+	URL     string
+	Caption string
+	// Index is the position of the media in the gallery, starting from 1.
+	Index  int
+	Width  int
+	Height int
+}
+
+// Orientation calculates the orientation of the image.
+func (g *GalleryImage) Orientation() string {
+	return orientation(g.Width, g.Height)
 }
 
 type Image struct {
@@ -45,24 +128,147 @@ type Image struct {
 	} `json:"source"`
 }
 
+// Name returns the fullname of the post, e.g. t3_11tug3p.
+func (p *Post) Name() string {
+	return p.Data.Name
+}
+
+// ID returns the id of the post, e.g. 11tug3p.
+func (p *Post) ID() string {
+	return p.Data.ID
+}
+
+// Author returns the username of the post author, it's "[deleted]" for deleted accounts.
+func (p *Post) Author() string {
+	return p.Data.Author
+}
+
+// Subreddit returns the name of the subreddit the post was submitted to (not the one of the original post for crossposts).
+func (p *Post) Subreddit() string {
+	return p.Data.Subreddit
+}
+
+// Score returns the score of the post, the upvotes minus the downvotes.
+func (p *Post) Score() int {
+	return p.Data.Score
+}
+
+// Ups returns the amount of upvotes, reddit reports it equal to the score.
+func (p *Post) Ups() int {
+	return p.Data.Ups
+}
+
+// UpvoteRatio returns the fraction of the votes that are upvotes, from 0 to 1.
+func (p *Post) UpvoteRatio() float64 {
+	return p.Data.UpvoteRatio
+}
+
+// NumComments returns the amount of comments on the post.
+func (p *Post) NumComments() int {
+	return p.Data.NumComments
+}
+
+// Created returns the creation time of the post in UTC, or the zero time if it's unknown.
+func (p *Post) Created() time.Time {
+	if p.Data.CreatedUTC <= 0 {
+		return time.Time{}
+	}
+	sec, frac := math.Modf(p.Data.CreatedUTC)
+	return time.Unix(int64(sec), int64(frac*1e9)).UTC()
+}
+
+// Permalink returns the url of the post page, or an empty string if it's unknown.
+func (p *Post) Permalink() string {
+	if p.Data.Permalink == "" {
+		return ""
+	}
+	return "https://www.reddit.com" + p.Data.Permalink
+}
+
+// Flair returns the text of the post flair, or an empty string if there is none.
+func (p *Post) Flair() string {
+	return p.Data.LinkFlairText
+}
+
+// Domain returns the domain of the post url, e.g. i.redd.it.
+func (p *Post) Domain() string {
+	return p.Data.Domain
+}
+
+// IsNSFW reports whether the post is marked as NSFW (over 18).
+func (p *Post) IsNSFW() bool {
+	return p.Data.Over18
+}
+
+// IsSpoiler reports whether the post is marked as a spoiler.
+func (p *Post) IsSpoiler() bool {
+	return p.Data.Spoiler
+}
+
+// IsStickied reports whether the post is pinned to the top of the subreddit.
+func (p *Post) IsStickied() bool {
+	return p.Data.Stickied
+}
+
+// IsComment reports whether the item is a comment, not a post.
+// The media of a comment is the media of the post it was left on.
+func (p *Post) IsComment() bool {
+	return p.Kind == "t1"
+}
+
+// IsCrosspost reports whether the post is a crosspost of another one.
+func (p *Post) IsCrosspost() bool {
+	return p.Data.CrosspostParent != "" || len(p.Data.CrosspostParentList) != 0
+}
+
+// OriginalName returns the name (e.g. t3_11tug3p) of the original post for crossposts, or the post name otherwise.
+func (p *Post) OriginalName() string {
+	if len(p.Data.CrosspostParentList) != 0 && p.Data.CrosspostParentList[0].Name != "" {
+		return p.Data.CrosspostParentList[0].Name
+	}
+	if p.Data.CrosspostParent != "" {
+		return p.Data.CrosspostParent
+	}
+	return p.Data.Name
+}
+
+// media returns the data that describes the media of the post,
+// crossposts carry it in the original post.
+func (p *Post) media() *PostData {
+	if len(p.Data.CrosspostParentList) != 0 {
+		return &p.Data.CrosspostParentList[0]
+	}
+	return &p.Data
+}
+
 // Width returns either the width of video/image, or 0.
+// For galleries, it is the width of the first image.
 func (p *Post) Width() int {
-	if p.Data.IsVideo {
-		return p.Data.Media.RedditVideo.Width
+	data := p.media()
+	if data.IsVideo && data.Media.RedditVideo != nil {
+		return data.Media.RedditVideo.Width
 	}
-	if len(p.Data.Preview.Images) != 0 {
-		return p.Data.Preview.Images[0].Source.Width
+	if gallery := p.Gallery(); len(gallery) != 0 {
+		return gallery[0].Width
+	}
+	if len(data.Preview.Images) != 0 && data.Preview.Images[0].Source != nil {
+		return data.Preview.Images[0].Source.Width
 	}
 	return 0
 }
 
 // Height returns either the height of video/image, or 0.
+// For galleries, it is the height of the first image.
 func (p *Post) Height() int {
-	if p.Data.IsVideo {
-		return p.Data.Media.RedditVideo.Height
+	data := p.media()
+	if data.IsVideo && data.Media.RedditVideo != nil {
+		return data.Media.RedditVideo.Height
+	}
+	if gallery := p.Gallery(); len(gallery) != 0 {
+		return gallery[0].Height
 	}
-	if len(p.Data.Preview.Images) != 0 {
-		return p.Data.Preview.Images[0].Source.Height
+	if len(data.Preview.Images) != 0 && data.Preview.Images[0].Source != nil {
+		return data.Preview.Images[0].Source.Height
 	}
 	return 0
 }
@@ -74,7 +280,10 @@ func (p *Post) Dimensions() (w, h int) {
 
 // Orientation calculates the orientation of video/image.
 func (p *Post) Orientation() string {
-	width, height := p.Dimensions()
+	return orientation(p.Dimensions())
+}
+
+func orientation(width, height int) string {
 	if width > height {
 		return "landscape"
 	}
@@ -84,19 +293,108 @@ func (p *Post) Orientation() string {
 	return "rect"
 }
 
-// Title is just the post title.
+// Title is just the post title, for comments it is the title of their post.
 func (p *Post) Title() string {
+	if p.IsComment() {
+		return p.Data.LinkTitle
+	}
 	return p.Data.Title
 }
 
-// URL returns an automatically formatted url of the post.
+// URL returns an automatically formatted url of the post, for crossposts it is the url of the original media.
+// For reddit videos, it is the video without audio, see Video.DashURL for the video with audio.
 func (p *Post) URL() string {
-	if p.Data.IsVideo {
-		return strings.ReplaceAll(p.Data.Media.RedditVideo.ScrubberMediaURL, "&amp;", "&")
+	if p.IsComment() {
+		return strings.ReplaceAll(p.Data.LinkURL, "&amp;", "&")
 	}
-	return strings.ReplaceAll(p.Data.URL, "&amp;", "&")
+	data := p.media()
+	if video := data.Media.RedditVideo; data.IsVideo && video != nil {
+		if video.FallbackURL != "" {
+			return strings.ReplaceAll(video.FallbackURL, "&amp;", "&")
+		}
+		return strings.ReplaceAll(video.ScrubberMediaURL, "&amp;", "&")
+	}
+	return strings.ReplaceAll(data.URL, "&amp;", "&")
 }
 
+// Video returns the reddit video of the post (or of the original post for crossposts), or nil.
+func (p *Post) Video() *Video {
+	if data := p.media(); data.IsVideo {
+		return data.Media.RedditVideo
+	}
+	return nil
+}
+
+// Type returns the post hint, galleries are considered images, and reddit videos ("hosted:video") are videos.
+// For crossposts, it is the type of the original post.
 func (p *Post) Type() string {
-	return p.Data.PostHint
+	if p.IsComment() {
+		return typeFromURL(p.URL())
+	}
+	data := p.media()
+	if data.IsGallery {
+		return "image"
+	}
+	if data.IsVideo {
+		return "video"
+	}
+	return data.PostHint
+}
+
+// typeFromURL guesses the media type by the url extension, it's used when there's no post hint.
+func typeFromURL(surl string) string {
+	u, err := url.Parse(surl)
+	if err != nil {
+		return ""
+	}
+	switch strings.ToLower(path.Ext(u.Path)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+		return "image"
+	case ".mp4":
+		return "video"
+	default:
+		return "link"
+	}
+}
+
+// Gallery returns the media of a gallery post in the gallery order, or nil if the post is not a gallery.
+// The media that reddit failed to process is omitted, but the indices stay the same.
+// For crossposts, it is the gallery of the original post.
+func (p *Post) Gallery() []GalleryImage {
+	data := p.media()
+	if !data.IsGallery || data.GalleryData == nil {
+		return nil
+	}
+
+	images := make([]GalleryImage, 0, len(data.GalleryData.Items))
+	for i, gi := range data.GalleryData.Items {
+		meta, ok := data.MediaMetadata[gi.MediaID]
+		if !ok || meta.Status != "valid" {
+			continue
+		}
+		images = append(images, GalleryImage{
+			MediaID: gi.MediaID,
+			URL:     meta.url(),
+			Caption: gi.Caption,
+			Index:   i + 1,
+			Width:   meta.Source.Width,
+			Height:  meta.Source.Height,
+		})
+	}
+
+	return images
+}
+
+// url returns the url of the original media on i.redd.it, falling back to the preview url.
+func (m *MediaMetadata) url() string {
+	if m.Source.GIF != "" {
+		return strings.ReplaceAll(m.Source.GIF, "&amp;", "&")
+	}
+	if _, ext, found := strings.Cut(m.MIME, "/"); found && ext != "" {
+		return defaultBaseImageURL + "/" + m.ID + "." + ext
+	}
+	if m.Source.URL != "" {
+		return strings.ReplaceAll(m.Source.URL, "&amp;", "&")
+	}
+	return strings.ReplaceAll(m.Source.MP4, "&amp;", "&")
 }
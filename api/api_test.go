@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGallery(t *testing.T) {
+	t.Parallel()
+	p := GetSavedGallery(t)
+
+	assert.Equal(t, "image", p.Type(), "galleries should be considered images")
+	notGallery := GetSavedPost(t)
+	assert.Nil(t, notGallery.Gallery(), "not a gallery")
+
+	gallery := p.Gallery()
+	assert.Equal(t, 3, len(gallery), "failed media should be omitted")
+
+	assert.Equal(t, "h7i8j9k0l1m2n", gallery[0].MediaID)
+	assert.Equal(t, "https://i.redd.it/h7i8j9k0l1m2n.png", gallery[0].URL)
+	assert.Equal(t, 1, gallery[0].Index)
+	assert.Equal(t, "portrait", gallery[0].Orientation())
+
+	assert.Equal(t, "https://i.redd.it/a1b2c3d4e5f6g.jpg", gallery[1].URL)
+	assert.Equal(t, "The second lake", gallery[1].Caption)
+	assert.Equal(t, 2, gallery[1].Index)
+	assert.Equal(t, 3840, gallery[1].Width)
+	assert.Equal(t, 2160, gallery[1].Height)
+
+	assert.Equal(t, "https://i.redd.it/o3p4q5r6s7t8u.gif", gallery[2].URL)
+	assert.Equal(t, 4, gallery[2].Index, "indices should be stable")
+
+	w, h := p.Dimensions()
+	assert.Equal(t, 1080, w, "unexpected gallery width")
+	assert.Equal(t, 2400, h, "unexpected gallery height")
+}
+
+func TestPostFields(t *testing.T) {
+	t.Parallel()
+	p := GetSavedPost(t)
+
+	assert.Equal(t, "t3_11tug3p", p.Name())
+	assert.Equal(t, "11tug3p", p.ID())
+	assert.Equal(t, "The_Romero", p.Author())
+	assert.Equal(t, "wallpaper", p.Subreddit())
+	assert.Equal(t, 474, p.Score())
+	assert.Equal(t, 474, p.Ups())
+	assert.Equal(t, 0.97, p.UpvoteRatio())
+	assert.Equal(t, 1, p.NumComments())
+	assert.Equal(t, time.Date(2023, time.March, 17, 15, 32, 42, 0, time.UTC), p.Created())
+	assert.Equal(t, "https://www.reddit.com/r/wallpaper/comments/11tug3p/staring_into_the_woods_3840x2160/", p.Permalink())
+	assert.Equal(t, "", p.Flair())
+	assert.Equal(t, "i.redd.it", p.Domain())
+	assert.False(t, p.IsNSFW())
+	assert.False(t, p.IsSpoiler())
+	assert.False(t, p.IsStickied())
+
+	var empty Post
+	assert.True(t, empty.Created().IsZero())
+	assert.Equal(t, "", empty.Permalink())
+}
+
+func TestCrosspost(t *testing.T) {
+	t.Parallel()
+	b, err := os.ReadFile("testdata/crosspost.json")
+	assert.NoError(t, err)
+	var ps Posts
+	assert.NoError(t, json.Unmarshal(b, &ps))
+	assert.Equal(t, 1, len(ps.Data.Children))
+	p := ps.Data.Children[0]
+
+	assert.True(t, p.IsCrosspost())
+	assert.Equal(t, "t3_11tug3p", p.OriginalName())
+	assert.Equal(t, "image", p.Type(), "type should come from the original post")
+	assert.Equal(t, "https://i.redd.it/05sk8tzriboa1.png", p.URL(), "media should come from the original post")
+	w, h := p.Dimensions()
+	assert.Equal(t, 6656, w, "unexpected crosspost width")
+	assert.Equal(t, 3840, h, "unexpected crosspost height")
+	assert.Equal(t, "WidescreenWallpaper", p.Data.Subreddit, "crosspost should stay in its subreddit")
+
+	original := GetSavedPost(t)
+	assert.False(t, original.IsCrosspost())
+	assert.Equal(t, "t3_11tug3p", original.OriginalName())
+}
+
+func GetSavedGallery(t *testing.T) Post {
+	t.Helper()
+	b, err := os.ReadFile("testdata/gallery.json")
+	assert.NoError(t, err)
+
+	var ps Posts
+	assert.NoError(t, json.Unmarshal(b, &ps))
+	assert.Equal(t, 1, len(ps.Data.Children))
+
+	return ps.Data.Children[0]
+}
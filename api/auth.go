@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAuthURL  = "https://www.reddit.com"
+	defaultOAuthURL = "https://oauth.reddit.com"
+	accessTokenPath = "/api/v1/access_token"
+
+	// installedClientGrant is the grant used for app-only access of installed apps, which have no secret.
+	installedClientGrant = "https://oauth.reddit.com/grants/installed_client"
+	// tokenExpiryDelta is how long before the actual expiry the token is considered expired.
+	tokenExpiryDelta = 30 * time.Second
+)
+
+// Credentials are used to authenticate the client using one of the reddit OAuth2 flows.
+// The flow is picked based on the provided fields:
+//   - RefreshToken: installed/web app with a refresh token from the code flow;
+//   - Username and Password: script app, password grant;
+//   - otherwise: app-only access (client credentials, or installed client if there's no secret).
+type Credentials struct {
+	ClientID     string
+	ClientSecret string
+	Username     string
+	Password     string
+	RefreshToken string
+}
+
+// Token is the access token returned by reddit.
+type Token struct {
+	AccessToken  string    `json:"access_token"`
+	TokenType    string    `json:"token_type"`
+	RefreshToken string    `json:"refresh_token"`
+	Scope        string    `json:"scope"`
+	ExpiresIn    int64     `json:"expires_in"`
+	Expiry       time.Time `json:"-"`
+}
+
+// Valid reports whether the token is present and is not about to expire.
+func (t *Token) Valid() bool {
+	if t == nil || t.AccessToken == "" {
+		return false
+	}
+	if t.Expiry.IsZero() {
+		return true
+	}
+	return time.Now().Add(tokenExpiryDelta).Before(t.Expiry)
+}
+
+// AuthError is returned when reddit refuses to issue a token.
+type AuthError struct {
+	Status      string
+	Code        string
+	Description string
+}
+
+func (e *AuthError) Error() string {
+	msg := "authentication failed"
+	if e.Status != "" {
+		msg += " (" + e.Status + ")"
+	}
+	if e.Code != "" {
+		msg += ": " + e.Code
+	}
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return msg
+}
+
+type authenticator struct {
+	mu    sync.Mutex
+	creds Credentials
+	token *Token
+	// username is the name of the authenticated user, once it's known.
+	username string
+}
+
+func (a *authenticator) values() url.Values {
+	values := url.Values{}
+	switch {
+	case a.creds.RefreshToken != "":
+		values.Set("grant_type", "refresh_token")
+		values.Set("refresh_token", a.creds.RefreshToken)
+	case a.creds.Username != "" && a.creds.Password != "":
+		values.Set("grant_type", "password")
+		values.Set("username", a.creds.Username)
+		values.Set("password", a.creds.Password)
+	case a.creds.ClientSecret != "":
+		values.Set("grant_type", "client_credentials")
+	default:
+		values.Set("grant_type", installedClientGrant)
+		values.Set("device_id", "DO_NOT_TRACK_THIS_DEVICE")
+	}
+	return values
+}
+
+// Authenticated reports whether the client was configured with credentials.
+func (c *Client) Authenticated() bool {
+	return c.auth != nil
+}
+
+// Token returns a valid access token, fetching a new one if the current token has expired.
+func (c *Client) Token(ctx context.Context) (*Token, error) {
+	if c.auth == nil {
+		return nil, fmt.Errorf("client has no credentials")
+	}
+
+	c.auth.mu.Lock()
+	defer c.auth.mu.Unlock()
+
+	if c.auth.token.Valid() {
+		return c.auth.token, nil
+	}
+
+	token, err := c.fetchToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if token.RefreshToken != "" {
+		c.auth.creds.RefreshToken = token.RefreshToken
+	}
+	c.auth.token = token
+
+	return token, nil
+}
+
+// invalidateToken forces the next call to Token to fetch a new token.
+func (c *Client) invalidateToken() {
+	if c.auth == nil {
+		return
+	}
+	c.auth.mu.Lock()
+	c.auth.token = nil
+	c.auth.mu.Unlock()
+}
+
+func (c *Client) fetchToken(ctx context.Context) (*Token, error) {
+	u := c.authbase.JoinPath(accessTokenPath)
+	body := strings.NewReader(c.auth.values().Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.auth.creds.ClientID, c.auth.creds.ClientSecret)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", c.userAgent)
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var tr struct {
+		Token
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tr); err != nil && res.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("%w: failed to decode token response", err)
+	}
+	if res.StatusCode != http.StatusOK || tr.Error != "" || tr.AccessToken == "" {
+		return nil, &AuthError{
+			Status:      res.Status,
+			Code:        tr.Error,
+			Description: tr.ErrorDescription,
+		}
+	}
+
+	token := tr.Token
+	if token.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	}
+
+	return &token, nil
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newAuthServer(t *testing.T, expiresIn int64, wantGrant string, tokens *atomic.Int32) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc(accessTokenPath, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		id, secret, ok := r.BasicAuth()
+		assert.True(t, ok, "missing basic auth")
+		assert.Equal(t, "id", id)
+		assert.Equal(t, "secret", secret)
+		assert.NoError(t, r.ParseForm())
+		assert.Equal(t, wantGrant, r.PostForm.Get("grant_type"))
+
+		if r.PostForm.Get("password") == "wrong" {
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"error": "invalid_grant"}`))
+			return
+		}
+
+		tokens.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(t, json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "token",
+			"token_type":   "bearer",
+			"expires_in":   expiresIn,
+			"scope":        "*",
+		}))
+	})
+	mux.HandleFunc("/r/wallpaper/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		b, err := os.ReadFile("testdata/sample.json")
+		assert.NoError(t, err)
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(b)
+		assert.NoError(t, err)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newAuthClient(t *testing.T, server *httptest.Server, creds Credentials) *Client {
+	t.Helper()
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	return DefaultClient().WithAuthURL(u).WithOAuthURL(u).WithCredentials(creds)
+}
+
+func TestPasswordGrant(t *testing.T) {
+	t.Parallel()
+	var tokens atomic.Int32
+	server := newAuthServer(t, 3600, "password", &tokens)
+	c := newAuthClient(t, server, Credentials{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Username:     "user",
+		Password:     "pass",
+	})
+
+	for i := 0; i < 3; i++ {
+		posts, _, err := c.Subreddit.GetPosts(context.TODO(), &RequestOptions{Subreddit: "wallpaper", Sorting: "best"})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(posts), "unexpected decoded response")
+	}
+	assert.Equal(t, int32(1), tokens.Load(), "token should be reused until it expires")
+}
+
+func TestTokenRefresh(t *testing.T) {
+	t.Parallel()
+	var tokens atomic.Int32
+	// The token expires sooner than tokenExpiryDelta, so it has to be refreshed every time.
+	server := newAuthServer(t, 1, "refresh_token", &tokens)
+	c := newAuthClient(t, server, Credentials{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		RefreshToken: "refresh",
+	})
+
+	for i := 0; i < 3; i++ {
+		_, _, err := c.Subreddit.GetPosts(context.TODO(), &RequestOptions{Subreddit: "wallpaper", Sorting: "best"})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(3), tokens.Load(), "expired token should be refreshed")
+}
+
+func TestClientCredentialsGrant(t *testing.T) {
+	t.Parallel()
+	var tokens atomic.Int32
+	server := newAuthServer(t, 3600, "client_credentials", &tokens)
+	c := newAuthClient(t, server, Credentials{ClientID: "id", ClientSecret: "secret"})
+
+	token, err := c.Token(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, "token", token.AccessToken)
+	assert.True(t, token.Valid())
+}
+
+func TestInvalidGrant(t *testing.T) {
+	t.Parallel()
+	var tokens atomic.Int32
+	server := newAuthServer(t, 3600, "password", &tokens)
+	c := newAuthClient(t, server, Credentials{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Username:     "user",
+		Password:     "wrong",
+	})
+
+	_, err := c.Token(context.TODO())
+	var authErr *AuthError
+	assert.ErrorAs(t, err, &authErr)
+	assert.Equal(t, "invalid_grant", authErr.Code)
+}
+
+func TestOAuthURLFormatting(t *testing.T) {
+	t.Parallel()
+	const correct = "https://oauth.reddit.com/r/example/best.json?after=&limit=10&t=all"
+	c := DefaultClient().WithCredentials(Credentials{ClientID: "id"})
+	res := c.optsURL(&RequestOptions{Count: 10, Sorting: "best", Timeframe: "all", Subreddit: "example"})
+	assert.Equal(t, correct, res, "incorrect url format")
+}
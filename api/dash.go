@@ -0,0 +1,410 @@
+package api
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// VideoOptions control how reddit videos (with separate video and audio tracks) are downloaded.
+type VideoOptions struct {
+	// MaxWidth and MaxHeight limit the resolution of the downloaded video, 0 means no limit.
+	MaxWidth  int
+	MaxHeight int
+	// FFmpeg is the path to the ffmpeg executable used to mux the tracks,
+	// if it's empty, the tracks are muxed in Go.
+	FFmpeg string
+}
+
+// WithVideoOptions sets the options used to download reddit videos.
+func (c *Client) WithVideoOptions(opts VideoOptions) *Client {
+	c.video = opts
+	return c
+}
+
+var dashContentTypes = []string{"application/dash+xml", "application/xml", "text/xml"}
+
+// mpd is the part of the DASH manifest (Media Presentation Description) that is needed to find the tracks.
+type mpd struct {
+	BaseURL string      `xml:"BaseURL"`
+	Periods []mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	BaseURL        string             `xml:"BaseURL"`
+	AdaptationSets []mpdAdaptationSet `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	ContentType     string              `xml:"contentType,attr"`
+	MimeType        string              `xml:"mimeType,attr"`
+	BaseURL         string              `xml:"BaseURL"`
+	SegmentList     *mpdSegmentList     `xml:"SegmentList"`
+	Representations []mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID          string          `xml:"id,attr"`
+	MimeType    string          `xml:"mimeType,attr"`
+	Width       int             `xml:"width,attr"`
+	Height      int             `xml:"height,attr"`
+	Bandwidth   int64           `xml:"bandwidth,attr"`
+	BaseURL     string          `xml:"BaseURL"`
+	SegmentList *mpdSegmentList `xml:"SegmentList"`
+}
+
+type mpdSegmentList struct {
+	Initialization *struct {
+		SourceURL string `xml:"sourceURL,attr"`
+	} `xml:"Initialization"`
+	SegmentURLs []struct {
+		Media string `xml:"media,attr"`
+	} `xml:"SegmentURL"`
+}
+
+// dashTrack is a representation of the video or audio in the manifest.
+type dashTrack struct {
+	// URLs are the segments of the track, their concatenation is a fragmented MP4 file.
+	URLs      []string
+	Width     int
+	Height    int
+	Bandwidth int64
+}
+
+// parseMPD returns the video and audio tracks of the manifest, relative urls are resolved against base.
+func parseMPD(r io.Reader, base *url.URL) (video, audio []dashTrack, err error) {
+	var manifest mpd
+	if err := xml.NewDecoder(r).Decode(&manifest); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode the DASH manifest: %w", err)
+	}
+
+	resolve := func(base *url.URL, ref string) (*url.URL, error) {
+		if ref = strings.TrimSpace(ref); ref == "" {
+			return base, nil
+		}
+		u, err := url.Parse(ref)
+		if err != nil {
+			return nil, err
+		}
+		return base.ResolveReference(u), nil
+	}
+
+	mpdBase, err := resolve(base, manifest.BaseURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, period := range manifest.Periods {
+		periodBase, err := resolve(mpdBase, period.BaseURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, set := range period.AdaptationSets {
+			setBase, err := resolve(periodBase, set.BaseURL)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, rep := range set.Representations {
+				repBase, err := resolve(setBase, rep.BaseURL)
+				if err != nil {
+					return nil, nil, err
+				}
+				segments := rep.SegmentList
+				if segments == nil {
+					segments = set.SegmentList
+				}
+				track := dashTrack{Width: rep.Width, Height: rep.Height, Bandwidth: rep.Bandwidth}
+				if track.URLs, err = segments.urls(repBase); err != nil {
+					return nil, nil, err
+				}
+
+				switch contentType(set, rep) {
+				case "video":
+					video = append(video, track)
+				case "audio":
+					audio = append(audio, track)
+				}
+			}
+		}
+	}
+
+	if len(video) == 0 {
+		return nil, nil, fmt.Errorf("%w: no video in the DASH manifest", errUnsupportedMP4)
+	}
+
+	return video, audio, nil
+}
+
+// urls returns the segment urls, or the base url itself if the track is a single file.
+func (l *mpdSegmentList) urls(base *url.URL) ([]string, error) {
+	if l == nil {
+		return []string{base.String()}, nil
+	}
+
+	var refs []string
+	if l.Initialization != nil && l.Initialization.SourceURL != "" {
+		refs = append(refs, l.Initialization.SourceURL)
+	}
+	for _, segment := range l.SegmentURLs {
+		refs = append(refs, segment.Media)
+	}
+	if len(refs) == 0 {
+		return []string{base.String()}, nil
+	}
+
+	urls := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		u, err := url.Parse(ref)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, base.ResolveReference(u).String())
+	}
+	return urls, nil
+}
+
+// contentType returns either "video" or "audio", or an empty string for other tracks (e.g. subtitles).
+func contentType(set mpdAdaptationSet, rep mpdRepresentation) string {
+	for _, v := range []string{set.ContentType, rep.MimeType, set.MimeType} {
+		if kind, _, _ := strings.Cut(v, "/"); kind == "video" || kind == "audio" {
+			return kind
+		}
+	}
+	return ""
+}
+
+// selectVideo returns the video track with the highest resolution within the limits,
+// or the smallest one if none of them fit.
+func selectVideo(tracks []dashTrack, opts VideoOptions) *dashTrack {
+	fits := func(t *dashTrack) bool {
+		return (opts.MaxWidth <= 0 || t.Width <= opts.MaxWidth) && (opts.MaxHeight <= 0 || t.Height <= opts.MaxHeight)
+	}
+	better := func(a, b *dashTrack) bool {
+		if a.Width*a.Height != b.Width*b.Height {
+			return a.Width*a.Height > b.Width*b.Height
+		}
+		return a.Bandwidth > b.Bandwidth
+	}
+
+	var best, smallest *dashTrack
+	for i := range tracks {
+		t := &tracks[i]
+		if smallest == nil || better(smallest, t) {
+			smallest = t
+		}
+		if fits(t) && (best == nil || better(t, best)) {
+			best = t
+		}
+	}
+	if best == nil {
+		return smallest
+	}
+	return best
+}
+
+// selectAudio returns the audio track with the highest bandwidth, or nil if there are none.
+func selectAudio(tracks []dashTrack) *dashTrack {
+	var best *dashTrack
+	for i := range tracks {
+		if best == nil || tracks[i].Bandwidth > best.Bandwidth {
+			best = &tracks[i]
+		}
+	}
+	return best
+}
+
+// tracks fetches the DASH manifest and selects the video and audio tracks, audio is nil for silent videos.
+func (s *SubredditService) tracks(ctx context.Context, manifestURL string) (video, audio *dashTrack, err error) {
+	base, err := url.Parse(manifestURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	res, err := s.client.GetURL(ctx, manifestURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	if err := checkResponse(res, dashContentTypes); err != nil {
+		return nil, nil, err
+	}
+	videos, audios, err := parseMPD(res.Body, base)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return selectVideo(videos, s.client.video), selectAudio(audios), nil
+}
+
+// resumeVideo downloads a reddit video with its audio track into f.
+// The tracks are listed in the DASH manifest of the item, if it can't be used, the item url is downloaded instead.
+//
+// The muxed file is written from the start on each attempt, so it can't be resumed,
+// unless the video has no audio, in which case the video track is downloaded as is.
+func (s *SubredditService) resumeVideo(ctx context.Context, item *Item, f File, state *DownloadState) error {
+	video, audio, err := s.tracks(ctx, item.ManifestURL)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		log.Warn().Err(err).Str("url", item.ManifestURL).Msg("failed to use the DASH manifest, downloading the fallback video")
+		return s.resumeFile(ctx, item, f, state)
+	}
+
+	if audio == nil && len(video.URLs) == 1 {
+		silent := *item
+		silent.URL = video.URLs[0]
+		return s.resumeFile(ctx, &silent, f, state)
+	}
+
+	tracks := []*dashTrack{video}
+	if audio != nil {
+		tracks = append(tracks, audio)
+	}
+
+	*state = DownloadState{URL: item.URL}
+	err = s.client.retry.Do(ctx, func(int) error {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		state.Written = 0
+		return s.mux(ctx, &stateWriter{w: f, state: state}, tracks...)
+	})
+	// The muxed file can't be resumed, the next attempt has to start from scratch.
+	if err != nil {
+		state.Written = 0
+	}
+	if errors.Is(err, errUnsupportedMP4) && len(video.URLs) == 1 {
+		log.Warn().Err(err).Str("url", item.ManifestURL).Msg("failed to mux the video, downloading it without audio")
+		silent := *item
+		silent.URL = video.URLs[0]
+		return s.resumeFile(ctx, &silent, f, state)
+	}
+
+	return err
+}
+
+// mux writes the tracks muxed into a single MP4 file to w, using ffmpeg if it is configured.
+func (s *SubredditService) mux(ctx context.Context, w io.Writer, tracks ...*dashTrack) error {
+	if s.client.video.FFmpeg != "" {
+		return s.muxFFmpeg(ctx, w, tracks...)
+	}
+
+	readers := make([]io.Reader, 0, len(tracks))
+	for _, track := range tracks {
+		r := &segmentReader{ctx: ctx, client: s.client, urls: track.URLs}
+		defer r.Close()
+		readers = append(readers, r)
+	}
+	return muxMP4(w, readers...)
+}
+
+// muxFFmpeg downloads the tracks to temporary files and muxes them with ffmpeg.
+func (s *SubredditService) muxFFmpeg(ctx context.Context, w io.Writer, tracks ...*dashTrack) error {
+	dir, err := os.MkdirTemp("", "redditdl-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	args := []string{"-hide_banner", "-loglevel", "error", "-y"}
+	for i, track := range tracks {
+		path := filepath.Join(dir, fmt.Sprintf("track%d.mp4", i))
+		if err := s.downloadTrack(ctx, path, track); err != nil {
+			return err
+		}
+		args = append(args, "-i", path)
+	}
+	for i := range tracks {
+		args = append(args, "-map", strconv.Itoa(i))
+	}
+	output := filepath.Join(dir, "output.mp4")
+	args = append(args, "-c", "copy", "-f", "mp4", output)
+
+	if out, err := exec.CommandContext(ctx, s.client.video.FFmpeg, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	f, err := os.Open(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (s *SubredditService) downloadTrack(ctx context.Context, path string, track *dashTrack) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	r := &segmentReader{ctx: ctx, client: s.client, urls: track.URLs}
+	defer r.Close()
+
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// segmentReader reads the segments of a track one after another, as a single stream.
+type segmentReader struct {
+	ctx    context.Context
+	client *Client
+	urls   []string
+	body   io.ReadCloser
+}
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	for {
+		if r.body == nil {
+			if len(r.urls) == 0 {
+				return 0, io.EOF
+			}
+			res, err := r.client.GetURL(r.ctx, r.urls[0])
+			if err != nil {
+				return 0, err
+			}
+			if err := checkResponse(res, mediaContentTypes); err != nil {
+				res.Body.Close()
+				return 0, err
+			}
+			r.body, r.urls = res.Body, r.urls[1:]
+		}
+
+		n, err := r.body.Read(p)
+		if errors.Is(err, io.EOF) {
+			r.body.Close()
+			r.body = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *segmentReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testManifest = `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" mediaPresentationDuration="PT3S" type="static">
+  <Period duration="PT3S">
+    <AdaptationSet contentType="video" segmentAlignment="true">
+      <Representation id="VIDEO-1" mimeType="video/mp4" width="1920" height="1080" bandwidth="4000000">
+        <BaseURL>DASH_1080.mp4</BaseURL>
+      </Representation>
+      <Representation id="VIDEO-2" mimeType="video/mp4" width="1280" height="720" bandwidth="2000000">
+        <BaseURL>DASH_720.mp4</BaseURL>
+      </Representation>
+      <Representation id="VIDEO-3" mimeType="video/mp4" width="640" height="360" bandwidth="500000">
+        <BaseURL>DASH_360.mp4</BaseURL>
+      </Representation>
+    </AdaptationSet>
+    <AdaptationSet contentType="audio" lang="en">
+      <Representation id="AUDIO-1" mimeType="audio/mp4" bandwidth="64000">
+        <BaseURL>DASH_AUDIO_64.mp4</BaseURL>
+      </Representation>
+      <Representation id="AUDIO-2" mimeType="audio/mp4" bandwidth="128000">
+        <SegmentList>
+          <Initialization sourceURL="audio/init.mp4"/>
+          <SegmentURL media="audio/1.m4s"/>
+          <SegmentURL media="audio/2.m4s"/>
+        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+func TestParseMPD(t *testing.T) {
+	t.Parallel()
+	base, err := url.Parse("https://v.redd.it/abcdef/DASHPlaylist.mpd?a=1")
+	assert.NoError(t, err)
+
+	video, audio, err := parseMPD(strings.NewReader(testManifest), base)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(video))
+	assert.Equal(t, 2, len(audio))
+	assert.Equal(t, []string{"https://v.redd.it/abcdef/DASH_1080.mp4"}, video[0].URLs)
+	assert.Equal(t, []string{
+		"https://v.redd.it/abcdef/audio/init.mp4",
+		"https://v.redd.it/abcdef/audio/1.m4s",
+		"https://v.redd.it/abcdef/audio/2.m4s",
+	}, audio[1].URLs)
+
+	assert.Equal(t, 1080, selectVideo(video, VideoOptions{}).Height, "no limits should select the best video")
+	assert.Equal(t, 720, selectVideo(video, VideoOptions{MaxHeight: 720}).Height)
+	assert.Equal(t, 360, selectVideo(video, VideoOptions{MaxWidth: 1000}).Height)
+	assert.Equal(t, 360, selectVideo(video, VideoOptions{MaxHeight: 100}).Height, "the smallest video should be selected if none fit")
+	assert.Equal(t, int64(128000), selectAudio(audio).Bandwidth)
+	assert.Nil(t, selectAudio(nil))
+
+	_, _, err = parseMPD(strings.NewReader("<MPD></MPD>"), base)
+	assert.Error(t, err, "manifest without video should not be accepted")
+}
+
+func TestResumeVideo(t *testing.T) {
+	t.Parallel()
+	video := testMP4(1, 30, false, testFragment{0, "video 0"}, testFragment{30, "video 1"})
+	audio := testMP4(1, 48000, false, testFragment{0, "audio 0"}, testFragment{48000, "audio 1"})
+	// The audio is served in segments: the header, then a fragment per segment.
+	audioInit := bytes.Index(audio, []byte("moof")) - 4
+	audioSecond := bytes.LastIndex(audio, []byte("moof")) - 4
+
+	files := map[string][]byte{
+		"/video/DASH_1080.mp4":     []byte("1080p video"),
+		"/video/DASH_720.mp4":      video,
+		"/video/DASH_360.mp4":      []byte("360p video"),
+		"/video/DASH_AUDIO_64.mp4": []byte("low quality audio"),
+		"/video/audio/init.mp4":    audio[:audioInit],
+		"/video/audio/1.m4s":       audio[audioInit:audioSecond],
+		"/video/audio/2.m4s":       audio[audioSecond:],
+		"/silent/DASH_720.mp4":     video,
+		"/fallback.mp4":            []byte("fallback video"),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".mpd") {
+			manifest := testManifest
+			switch r.URL.Path {
+			case "/silent/DASHPlaylist.mpd":
+				manifest = strings.NewReplacer(`contentType="audio"`, `contentType="text"`, "audio/mp4", "text/vtt").Replace(testManifest)
+			case "/missing/DASHPlaylist.mpd":
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Content-Type", "application/dash+xml")
+			_, err := w.Write([]byte(manifest))
+			assert.NoError(t, err)
+			return
+		}
+		b, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "video/mp4")
+		_, err := w.Write(b)
+		assert.NoError(t, err)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := DefaultClient().
+		WithRetryPolicy(testRetryPolicy()).
+		WithVideoOptions(VideoOptions{MaxWidth: 1280, MaxHeight: 720})
+
+	var p Post
+	p.Data.IsVideo = true
+	p.Data.PostHint = "hosted:video"
+	p.Data.Media.RedditVideo = &Video{
+		FallbackURL: server.URL + "/fallback.mp4?source=fallback",
+		DashURL:     server.URL + "/video/DASHPlaylist.mpd?a=1&amp;b=2",
+		Width:       1920,
+		Height:      1080,
+		HasAudio:    true,
+	}
+	item, err := c.Subreddit.PostToItem(context.TODO(), &p)
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/fallback.mp4?source=fallback", item.URL)
+	assert.Equal(t, server.URL+"/video/DASHPlaylist.mpd?a=1&b=2", item.ManifestURL)
+
+	t.Run("Mux video and audio", func(t *testing.T) {
+		f := newPartialFile(t, []byte("garbage from a previous attempt"))
+		state := &DownloadState{URL: item.URL, Written: 31}
+		assert.NoError(t, c.Subreddit.Resume(context.TODO(), item, f, state))
+
+		b, err := os.ReadFile(f.Name())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(b)), state.Written)
+		boxes, err := parseBoxes(b)
+		assert.NoError(t, err)
+		var data []string
+		for _, box := range boxes {
+			if box.typ == "mdat" {
+				data = append(data, string(box.payload))
+			}
+		}
+		assert.Equal(t, []string{"video 0", "audio 0", "video 1", "audio 1"}, data)
+	})
+
+	t.Run("Download silent video", func(t *testing.T) {
+		silent := *item
+		silent.ManifestURL = server.URL + "/silent/DASHPlaylist.mpd"
+		f := newPartialFile(t, nil)
+		assert.NoError(t, c.Subreddit.Resume(context.TODO(), &silent, f, &DownloadState{}))
+		assertFile(t, f, video)
+	})
+
+	t.Run("Fall back without manifest", func(t *testing.T) {
+		missing := *item
+		missing.ManifestURL = server.URL + "/missing/DASHPlaylist.mpd"
+		f := newPartialFile(t, nil)
+		assert.NoError(t, c.Subreddit.Resume(context.TODO(), &missing, f, &DownloadState{}))
+		assertFile(t, f, []byte("fallback video"))
+	})
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errRangeNotSatisfiable = errors.New("requested range is not satisfiable")
+
+// Download fetches the media of the item and writes it to w, returning the amount of written bytes.
+// The media is streamed to w, so the memory usage doesn't depend on the media size.
+//
+// Transient errors are retried until the first byte is written,
+// after that w has to be discarded, as it contains a part of the media.
+// Use Resume to be able to continue broken downloads.
+func (s *SubredditService) Download(ctx context.Context, item *Item, w io.Writer) (int64, error) {
+	var written int64
+	err := s.client.retry.Do(ctx, func(int) error {
+		req, err := s.client.newRequest(ctx, http.MethodGet, item.URL, http.NoBody)
+		if err != nil {
+			return err
+		}
+		res, err := s.client.attempt(req, false)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+
+		if err := checkResponse(res, mediaContentTypes); err != nil {
+			return err
+		}
+
+		written, err = io.Copy(w, res.Body)
+		if err != nil && written > 0 {
+			return &partialWriteError{err: err}
+		}
+		return err
+	})
+
+	return written, err
+}
+
+// DownloadState is the progress of a download, it's enough to resume it later, even in another process.
+type DownloadState struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// Written is the amount of bytes at the start of the file that are already downloaded.
+	Written int64 `json:"written"`
+	// Size is the full size of the media, if the server reported it.
+	Size int64 `json:"size,omitempty"`
+}
+
+// File is the destination of a resumable download, *os.File implements it.
+type File interface {
+	io.Writer
+	io.Seeker
+	Truncate(size int64) error
+}
+
+// Resume fetches the media of the item into f, continuing from state.Written.
+// The download is resumed using the Range and If-Range headers, if the server does not support them,
+// or the media has changed since, f is truncated and the media is downloaded from the start.
+//
+// The state is kept up to date with the written bytes, so if Resume fails,
+// the state can be persisted along with f, and used to continue the download later.
+// Broken transfers are retried from where they stopped, for as long as they make progress.
+//
+// Reddit videos are muxed from the video and audio tracks of their DASH manifest,
+// such downloads are not resumable, they start from scratch each time.
+func (s *SubredditService) Resume(ctx context.Context, item *Item, f File, state *DownloadState) error {
+	if item.ManifestURL != "" {
+		return s.resumeVideo(ctx, item, f, state)
+	}
+	return s.resumeFile(ctx, item, f, state)
+}
+
+func (s *SubredditService) resumeFile(ctx context.Context, item *Item, f File, state *DownloadState) error {
+	if state.URL != item.URL {
+		*state = DownloadState{URL: item.URL}
+	}
+
+	for {
+		written := state.Written
+		err := s.client.retry.Do(ctx, func(int) error {
+			return s.resume(ctx, item, f, state)
+		})
+		// The attempts are only exhausted if they don't make any progress,
+		// e.g. large videos that take longer than the client timeout are downloaded in parts.
+		if err == nil || state.Written <= written || !s.client.retry.Retryable(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+func (s *SubredditService) resume(ctx context.Context, item *Item, f File, state *DownloadState) error {
+	req, err := s.client.newRequest(ctx, http.MethodGet, item.URL, http.NoBody)
+	if err != nil {
+		return err
+	}
+	if state.Written > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", state.Written))
+		// Weak validators can not be used with ranges.
+		if state.ETag != "" && !strings.HasPrefix(state.ETag, "W/") {
+			req.Header.Set("If-Range", state.ETag)
+		} else if state.LastModified != "" {
+			req.Header.Set("If-Range", state.LastModified)
+		}
+	}
+
+	res, err := s.client.attempt(req, false)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		if _, size, ok := parseContentRange(res.Header.Get("Content-Range")); ok && size == state.Written {
+			return nil // The previous attempt has already downloaded everything.
+		}
+		*state = DownloadState{URL: item.URL}
+		return errRangeNotSatisfiable
+	}
+	if err := checkResponse(res, mediaContentTypes); err != nil {
+		return err
+	}
+
+	offset := int64(0)
+	if res.StatusCode == http.StatusPartialContent {
+		start, size, ok := parseContentRange(res.Header.Get("Content-Range"))
+		if !ok || start != state.Written {
+			return fmt.Errorf("unexpected content range: %q", res.Header.Get("Content-Range"))
+		}
+		offset, state.Size = start, size
+	} else {
+		// The range was ignored, or the media has changed, start from scratch.
+		state.Size = res.ContentLength
+	}
+	state.Written = offset
+	state.ETag = res.Header.Get("ETag")
+	state.LastModified = res.Header.Get("Last-Modified")
+
+	if err := f.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(&stateWriter{w: f, state: state}, res.Body); err != nil {
+		return err
+	}
+	if state.Size > 0 && state.Written != state.Size {
+		return fmt.Errorf("%w: downloaded %d out of %d bytes", io.ErrUnexpectedEOF, state.Written, state.Size)
+	}
+
+	return nil
+}
+
+// stateWriter keeps the amount of written bytes in the state.
+type stateWriter struct {
+	w     io.Writer
+	state *DownloadState
+}
+
+func (sw *stateWriter) Write(p []byte) (int, error) {
+	n, err := sw.w.Write(p)
+	sw.state.Written += int64(n)
+	return n, err
+}
+
+// parseContentRange parses the "bytes start-end/size" and "bytes */size" values of the Content-Range header.
+// The size is -1 if it is unknown.
+func parseContentRange(value string) (start, size int64, ok bool) {
+	value, found := strings.CutPrefix(value, "bytes ")
+	if !found {
+		return 0, 0, false
+	}
+	rng, total, found := strings.Cut(value, "/")
+	if !found {
+		return 0, 0, false
+	}
+
+	size = -1
+	if total != "*" {
+		var err error
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	if rng == "*" {
+		return 0, size, true
+	}
+
+	first, _, found := strings.Cut(rng, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return start, size, true
+}
+
+// partialWriteError is returned when a transfer broke after something was already written.
+// It can't be retried, because the writer would receive the same bytes twice.
+type partialWriteError struct {
+	err error
+}
+
+func (e *partialWriteError) Error() string {
+	return "transfer failed after a partial write: " + e.err.Error()
+}
+
+func (e *partialWriteError) Unwrap() error {
+	return e.err
+}
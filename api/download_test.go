@@ -0,0 +1,189 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownload(t *testing.T) {
+	t.Parallel()
+	media := make([]byte, 4<<20)
+	_, err := rand.Read(media)
+	assert.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, err := w.Write(media)
+		assert.NoError(t, err)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var p Post
+	p.Data.URL = server.URL + "/image.png"
+	p.Data.PostHint = "image"
+
+	c := DefaultClient()
+	item, err := c.Subreddit.PostToItem(context.TODO(), &p)
+	assert.NoError(t, err)
+	assert.Equal(t, "image", item.Name, "unexpected name")
+	assert.Equal(t, "png", item.Extension, "unexpected extension")
+
+	var buf bytes.Buffer
+	n, err := c.Subreddit.Download(context.TODO(), item, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(media)), n)
+	assert.Equal(t, media, buf.Bytes(), "unexpected downloaded media")
+}
+
+func TestDownloadErrors(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/removed.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		_, err := w.Write([]byte("<html>removed</html>"))
+		assert.NoError(t, err)
+	})
+	mux.HandleFunc("/forbidden.jpg", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := DefaultClient()
+	tests := map[string]error{
+		"/removed.jpg":   ErrUnexpectedContentType,
+		"/forbidden.jpg": ErrForbidden,
+	}
+	for path, want := range tests {
+		var buf bytes.Buffer
+		_, err := c.Subreddit.Download(context.TODO(), &Item{URL: server.URL + path}, &buf)
+		assert.ErrorIs(t, err, want, "path=%s", path)
+		assert.Equal(t, 0, buf.Len(), "nothing should be written on errors")
+	}
+}
+
+func TestResume(t *testing.T) {
+	t.Parallel()
+	media := make([]byte, 1<<20)
+	_, err := rand.Read(media)
+	assert.NoError(t, err)
+	modtime := time.Now().Add(-time.Hour)
+
+	var (
+		ranges  atomic.Int32
+		broken  atomic.Bool
+		resumed atomic.Bool
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ranges.mp4", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") != "" {
+			ranges.Add(1)
+		}
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Header().Set("ETag", `"media"`)
+		http.ServeContent(w, r, "ranges.mp4", modtime, bytes.NewReader(media))
+	})
+	mux.HandleFunc("/broken.mp4", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "" && broken.CompareAndSwap(false, true) {
+			// Break the first transfer in the middle.
+			w.Header().Set("Content-Type", "video/mp4")
+			w.Header().Set("Content-Length", strconv.Itoa(len(media)))
+			_, err := w.Write(media[:len(media)/2])
+			assert.NoError(t, err)
+			return
+		}
+		resumed.Store(r.Header.Get("Range") == fmt.Sprintf("bytes=%d-", len(media)/2))
+		w.Header().Set("Content-Type", "video/mp4")
+		http.ServeContent(w, r, "broken.mp4", modtime, bytes.NewReader(media))
+	})
+	mux.HandleFunc("/noranges.mp4", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		_, err := w.Write(media)
+		assert.NoError(t, err)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	c := DefaultClient().WithRetryPolicy(testRetryPolicy())
+
+	t.Run("Resume a partial file", func(t *testing.T) {
+		item := &Item{URL: server.URL + "/ranges.mp4"}
+		f := newPartialFile(t, media[:1000])
+		state := &DownloadState{URL: item.URL, ETag: `"media"`, Written: 1000}
+
+		assert.NoError(t, c.Subreddit.Resume(context.TODO(), item, f, state))
+		assert.Equal(t, int32(1), ranges.Load(), "download should be resumed using a range")
+		assertFile(t, f, media)
+		assert.Equal(t, int64(len(media)), state.Written)
+	})
+
+	t.Run("Resume a broken transfer", func(t *testing.T) {
+		item := &Item{URL: server.URL + "/broken.mp4"}
+		f := newPartialFile(t, nil)
+		state := &DownloadState{}
+
+		assert.NoError(t, c.Subreddit.Resume(context.TODO(), item, f, state))
+		assert.True(t, resumed.Load(), "broken transfer should continue from where it stopped")
+		assertFile(t, f, media)
+	})
+
+	t.Run("Restart without range support", func(t *testing.T) {
+		item := &Item{URL: server.URL + "/noranges.mp4"}
+		f := newPartialFile(t, []byte("garbage"))
+		state := &DownloadState{URL: item.URL, Written: 7}
+
+		assert.NoError(t, c.Subreddit.Resume(context.TODO(), item, f, state))
+		assertFile(t, f, media)
+	})
+}
+
+func TestParseContentRange(t *testing.T) {
+	t.Parallel()
+	start, size, ok := parseContentRange("bytes 100-199/1000")
+	assert.True(t, ok)
+	assert.Equal(t, int64(100), start)
+	assert.Equal(t, int64(1000), size)
+
+	_, size, ok = parseContentRange("bytes */1000")
+	assert.True(t, ok)
+	assert.Equal(t, int64(1000), size)
+
+	_, size, ok = parseContentRange("bytes 100-199/*")
+	assert.True(t, ok)
+	assert.Equal(t, int64(-1), size)
+
+	_, _, ok = parseContentRange("items 1-2/3")
+	assert.False(t, ok)
+}
+
+func newPartialFile(t *testing.T, b []byte) *os.File {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "media.part"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { f.Close() })
+	_, err = f.Write(b)
+	assert.NoError(t, err)
+	return f
+}
+
+func assertFile(t *testing.T, f *os.File, want []byte) {
+	t.Helper()
+	b, err := os.ReadFile(f.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, len(want), len(b), "unexpected file size")
+	assert.True(t, bytes.Equal(want, b), "unexpected file contents")
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnauthorized          = errors.New("unauthorized")
+	ErrForbidden             = errors.New("forbidden")
+	ErrNotFound              = errors.New("not found")
+	ErrRateLimited           = errors.New("rate limited")
+	ErrUnavailable           = errors.New("unavailable")
+	ErrUnexpectedStatus      = errors.New("unexpected status")
+	ErrUnexpectedContentType = errors.New("unexpected content type")
+)
+
+var (
+	// jsonContentTypes are expected from the listing endpoints.
+	jsonContentTypes = []string{"application/json"}
+	// mediaContentTypes are expected from the media hosts, prefixes end with a slash.
+	mediaContentTypes = []string{"image/", "video/", "audio/", "application/octet-stream", "binary/octet-stream"}
+)
+
+// StatusError is returned when the server responded with an unexpected status.
+// It wraps one of ErrUnauthorized, ErrForbidden, ErrNotFound, ErrRateLimited, ErrUnavailable or ErrUnexpectedStatus.
+type StatusError struct {
+	URL        string
+	Status     string
+	StatusCode int
+	// RetryAfter is the delay requested by the server, if any.
+	RetryAfter time.Duration
+}
+
+func newStatusError(res *http.Response) *StatusError {
+	err := &StatusError{
+		URL:        res.Request.URL.String(),
+		Status:     res.Status,
+		StatusCode: res.StatusCode,
+	}
+	if d, ok := parseRetryAfter(res.Header, time.Now()); ok {
+		err.RetryAfter = d
+	}
+	return err
+}
+
+func (e *StatusError) Error() string {
+	return "unexpected response status " + e.Status + " (url=" + e.URL + ")"
+}
+
+func (e *StatusError) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case e.StatusCode == http.StatusForbidden:
+		return ErrForbidden
+	case e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone:
+		return ErrNotFound
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode == http.StatusRequestTimeout || e.StatusCode >= 500:
+		return ErrUnavailable
+	default:
+		return ErrUnexpectedStatus
+	}
+}
+
+// ContentTypeError is returned when the response has a content type that the caller did not expect,
+// e.g. an HTML error page instead of an image. It wraps ErrUnexpectedContentType.
+type ContentTypeError struct {
+	URL         string
+	ContentType string
+}
+
+func (e *ContentTypeError) Error() string {
+	return "unexpected content type " + e.ContentType + " (url=" + e.URL + ")"
+}
+
+func (e *ContentTypeError) Unwrap() error {
+	return ErrUnexpectedContentType
+}
+
+// checkResponse returns an error if the response status is not successful,
+// or if the content type doesn't match any of the wanted ones.
+// An empty content type is accepted, as there's no way to tell what it is.
+func checkResponse(res *http.Response, want []string) error {
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return newStatusError(res)
+	}
+
+	header := res.Header.Get("Content-Type")
+	if header == "" {
+		return nil
+	}
+	mediatype, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return &ContentTypeError{URL: res.Request.URL.String(), ContentType: header}
+	}
+	for _, w := range want {
+		if mediatype == w || strings.HasSuffix(w, "/") && strings.HasPrefix(mediatype, w) {
+			return nil
+		}
+	}
+
+	return &ContentTypeError{URL: res.Request.URL.String(), ContentType: mediatype}
+}
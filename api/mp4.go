@@ -0,0 +1,752 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// This file contains a minimal muxer for the fragmented MP4 files reddit serves in its DASH manifests.
+// Video and audio are separate files, each with a single track, so muxing them only requires
+// merging the headers (moov) and interleaving the fragments (moof+mdat), the samples are copied as is.
+
+var errUnsupportedMP4 = errors.New("unsupported mp4 file")
+
+const boxHeaderSize = 8
+
+type mp4Box struct {
+	typ     string
+	payload []byte
+}
+
+// size returns the full size of the box, including the header.
+func (b *mp4Box) size() int64 {
+	return int64(len(b.payload)) + boxHeaderSize
+}
+
+func (b *mp4Box) writeTo(w io.Writer) error {
+	if err := writeBoxHeader(w, b.typ, int64(len(b.payload))); err != nil {
+		return err
+	}
+	_, err := w.Write(b.payload)
+	return err
+}
+
+func writeBoxHeader(w io.Writer, typ string, payloadSize int64) error {
+	var header [16]byte
+	if size := payloadSize + boxHeaderSize; size <= math.MaxUint32 {
+		binary.BigEndian.PutUint32(header[:4], uint32(size))
+		copy(header[4:8], typ)
+		_, err := w.Write(header[:8])
+		return err
+	}
+	// Use the 64-bit size.
+	binary.BigEndian.PutUint32(header[:4], 1)
+	copy(header[4:8], typ)
+	binary.BigEndian.PutUint64(header[8:], uint64(payloadSize+16))
+	_, err := w.Write(header[:])
+	return err
+}
+
+// mp4Reader reads top-level boxes from a stream, keeping track of the position in it.
+type mp4Reader struct {
+	r   *bufio.Reader
+	pos int64
+}
+
+func newMP4Reader(r io.Reader) *mp4Reader {
+	return &mp4Reader{r: bufio.NewReader(r)}
+}
+
+// next reads the header of the next box and returns the box type and the payload size.
+// The payload size is -1 if the box extends to the end of the stream.
+func (m *mp4Reader) next() (typ string, size int64, err error) {
+	var header [16]byte
+	if _, err := io.ReadFull(m.r, header[:8]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", 0, fmt.Errorf("%w: truncated box header", errUnsupportedMP4)
+		}
+		return "", 0, err
+	}
+	m.pos += 8
+
+	typ = string(header[4:8])
+	switch size32 := binary.BigEndian.Uint32(header[:4]); size32 {
+	case 0:
+		return typ, -1, nil
+	case 1:
+		if _, err := io.ReadFull(m.r, header[8:16]); err != nil {
+			return "", 0, fmt.Errorf("%w: truncated box header", errUnsupportedMP4)
+		}
+		m.pos += 8
+		size = int64(binary.BigEndian.Uint64(header[8:16])) - 16
+	default:
+		size = int64(size32) - 8
+	}
+	if size < 0 {
+		return "", 0, fmt.Errorf("%w: invalid size of box %q", errUnsupportedMP4, typ)
+	}
+
+	return typ, size, nil
+}
+
+// maxBufferedBox limits the size of the boxes that are read into memory (everything except mdat).
+const maxBufferedBox = 64 << 20
+
+func (m *mp4Reader) readPayload(typ string, size int64) ([]byte, error) {
+	if size < 0 || size > maxBufferedBox {
+		return nil, fmt.Errorf("%w: box %q is too large", errUnsupportedMP4, typ)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if err := m.copyPayload(buf, size); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *mp4Reader) skip(size int64) error {
+	return m.copyPayload(io.Discard, size)
+}
+
+// copyPayload copies the payload of the current box to w.
+// The stream ending before the box does is reported as errUnsupportedMP4,
+// errors of the underlying reader (e.g. a broken transfer) are returned as is.
+func (m *mp4Reader) copyPayload(w io.Writer, size int64) error {
+	if size < 0 {
+		n, err := io.Copy(w, m.r)
+		m.pos += n
+		return err
+	}
+	n, err := io.CopyN(w, m.r, size)
+	m.pos += n
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: truncated box", errUnsupportedMP4)
+	}
+	return err
+}
+
+// parseBoxes parses the child boxes of a container box.
+func parseBoxes(data []byte) ([]mp4Box, error) {
+	var boxes []mp4Box
+	for len(data) > 0 {
+		if len(data) < boxHeaderSize {
+			return nil, fmt.Errorf("%w: truncated child box", errUnsupportedMP4)
+		}
+		size := int64(binary.BigEndian.Uint32(data[:4]))
+		typ := string(data[4:8])
+		header := int64(boxHeaderSize)
+		switch size {
+		case 0:
+			size = int64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("%w: truncated child box", errUnsupportedMP4)
+			}
+			size = int64(binary.BigEndian.Uint64(data[8:16]))
+			header = 16
+		}
+		if size < header || size > int64(len(data)) {
+			return nil, fmt.Errorf("%w: invalid size of child box %q", errUnsupportedMP4, typ)
+		}
+		boxes = append(boxes, mp4Box{typ: typ, payload: data[header:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// joinBoxes serializes the boxes into a container payload.
+func joinBoxes(boxes []mp4Box) []byte {
+	var size int64
+	for i := range boxes {
+		size += boxes[i].size()
+	}
+	out := make([]byte, 0, size)
+	for i := range boxes {
+		var header [8]byte
+		binary.BigEndian.PutUint32(header[:4], uint32(boxes[i].size()))
+		copy(header[4:], boxes[i].typ)
+		out = append(out, header[:]...)
+		out = append(out, boxes[i].payload...)
+	}
+	return out
+}
+
+func findBox(boxes []mp4Box, typ string) *mp4Box {
+	for i := range boxes {
+		if boxes[i].typ == typ {
+			return &boxes[i]
+		}
+	}
+	return nil
+}
+
+// findPath finds a nested box, e.g. findPath(moov, "trak", "mdia", "mdhd").
+func findPath(boxes []mp4Box, path ...string) (*mp4Box, error) {
+	for i, typ := range path {
+		box := findBox(boxes, typ)
+		if box == nil {
+			return nil, fmt.Errorf("%w: missing %q box", errUnsupportedMP4, typ)
+		}
+		if i == len(path)-1 {
+			return box, nil
+		}
+		var err error
+		if boxes, err = parseBoxes(box.payload); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%w: empty box path", errUnsupportedMP4)
+}
+
+// fullBoxField returns the offset of a field in a full box payload,
+// the offsets are given for version 0 and version 1 of the box, along with the field sizes.
+func fullBoxField(payload []byte, v0, v1 int, size0, size1 int) (offset, size int, err error) {
+	if len(payload) < 4 {
+		return 0, 0, fmt.Errorf("%w: truncated full box", errUnsupportedMP4)
+	}
+	offset, size = v0, size0
+	if payload[0] == 1 {
+		offset, size = v1, size1
+	}
+	if offset+size > len(payload) {
+		return 0, 0, fmt.Errorf("%w: truncated full box", errUnsupportedMP4)
+	}
+	return offset, size, nil
+}
+
+func getUint(b []byte, size int) uint64 {
+	if size == 8 {
+		return binary.BigEndian.Uint64(b)
+	}
+	return uint64(binary.BigEndian.Uint32(b))
+}
+
+func putUint(b []byte, size int, v uint64) {
+	if size == 8 {
+		binary.BigEndian.PutUint64(b, v)
+		return
+	}
+	if v > math.MaxUint32 {
+		v = math.MaxUint32
+	}
+	binary.BigEndian.PutUint32(b, uint32(v))
+}
+
+// Field offsets in the full box payloads (including version and flags).
+var (
+	mvhdTimescale = [4]int{12, 20, 4, 4}
+	mvhdDuration  = [4]int{16, 24, 4, 8}
+	mdhdTimescale = mvhdTimescale
+	tkhdTrackID   = [4]int{12, 20, 4, 4}
+	tkhdDuration  = [4]int{20, 28, 4, 8}
+	mehdDuration  = [4]int{4, 4, 4, 8}
+	tfdtTime      = [4]int{4, 4, 4, 8}
+)
+
+func readField(payload []byte, field [4]int) (uint64, error) {
+	offset, size, err := fullBoxField(payload, field[0], field[1], field[2], field[3])
+	if err != nil {
+		return 0, err
+	}
+	return getUint(payload[offset:], size), nil
+}
+
+func writeField(payload []byte, field [4]int, v uint64) error {
+	offset, size, err := fullBoxField(payload, field[0], field[1], field[2], field[3])
+	if err != nil {
+		return err
+	}
+	putUint(payload[offset:], size, v)
+	return nil
+}
+
+// mp4Input is one of the muxed files.
+type mp4Input struct {
+	r    *mp4Reader
+	ftyp *mp4Box
+	moov []mp4Box
+
+	// timescale of the movie header, durations in tkhd, elst and mehd use it.
+	movieTimescale uint64
+	// timescales of the tracks, by the original track id, decode times use it.
+	trackTimescales map[uint32]uint64
+	// trackIDs maps the original track ids to the ones in the output file.
+	trackIDs map[uint32]uint32
+
+	// moof is the header of the next fragment, nil when the input is exhausted.
+	moof    []byte
+	moofPos int64
+	time    float64
+}
+
+// readHeader reads the boxes until moov, the file must be fragmented.
+func (in *mp4Input) readHeader() error {
+	for {
+		typ, size, err := in.r.next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("%w: missing moov box", errUnsupportedMP4)
+			}
+			return err
+		}
+		switch typ {
+		case "ftyp", "moov":
+			payload, err := in.r.readPayload(typ, size)
+			if err != nil {
+				return err
+			}
+			if typ == "ftyp" {
+				in.ftyp = &mp4Box{typ: typ, payload: payload}
+				continue
+			}
+			if in.moov, err = parseBoxes(payload); err != nil {
+				return err
+			}
+			return in.parseMoov()
+		case "mdat", "moof":
+			return fmt.Errorf("%w: %q before moov", errUnsupportedMP4, typ)
+		default:
+			if err := in.r.skip(size); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (in *mp4Input) parseMoov() error {
+	if findBox(in.moov, "mvex") == nil {
+		return fmt.Errorf("%w: the file is not fragmented", errUnsupportedMP4)
+	}
+	mvhd, err := findPath(in.moov, "mvhd")
+	if err != nil {
+		return err
+	}
+	if in.movieTimescale, err = readField(mvhd.payload, mvhdTimescale); err != nil {
+		return err
+	}
+
+	in.trackTimescales = make(map[uint32]uint64)
+	in.trackIDs = make(map[uint32]uint32)
+	for i := range in.moov {
+		if in.moov[i].typ != "trak" {
+			continue
+		}
+		trak, err := parseBoxes(in.moov[i].payload)
+		if err != nil {
+			return err
+		}
+		tkhd, err := findPath(trak, "tkhd")
+		if err != nil {
+			return err
+		}
+		id, err := readField(tkhd.payload, tkhdTrackID)
+		if err != nil {
+			return err
+		}
+		mdhd, err := findPath(trak, "mdia", "mdhd")
+		if err != nil {
+			return err
+		}
+		timescale, err := readField(mdhd.payload, mdhdTimescale)
+		if err != nil {
+			return err
+		}
+		if timescale == 0 {
+			return fmt.Errorf("%w: zero timescale", errUnsupportedMP4)
+		}
+		in.trackTimescales[uint32(id)] = timescale
+		in.trackIDs[uint32(id)] = uint32(id)
+	}
+	if len(in.trackIDs) == 0 {
+		return fmt.Errorf("%w: no tracks", errUnsupportedMP4)
+	}
+	if in.movieTimescale == 0 {
+		return fmt.Errorf("%w: zero timescale", errUnsupportedMP4)
+	}
+
+	return nil
+}
+
+func (in *mp4Input) maxTrackID() uint32 {
+	var maxID uint32
+	for _, id := range in.trackIDs {
+		if id > maxID {
+			maxID = id
+		}
+	}
+	return maxID
+}
+
+// nextFragment reads the next moof box, skipping everything else, moof is nil at the end of the input.
+func (in *mp4Input) nextFragment() error {
+	in.moof = nil
+	for {
+		pos := in.r.pos
+		typ, size, err := in.r.next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if typ != "moof" {
+			if typ == "mdat" {
+				return fmt.Errorf("%w: mdat without moof", errUnsupportedMP4)
+			}
+			if err := in.r.skip(size); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if in.moof, err = in.r.readPayload(typ, size); err != nil {
+			return err
+		}
+		in.moofPos = pos
+		return in.fragmentTime()
+	}
+}
+
+// fragmentTime sets the decode time of the fragment in seconds, used to interleave the inputs.
+func (in *mp4Input) fragmentTime() error {
+	moof, err := parseBoxes(in.moof)
+	if err != nil {
+		return err
+	}
+	traf, err := findPath(moof, "traf")
+	if err != nil {
+		return err
+	}
+	children, err := parseBoxes(traf.payload)
+	if err != nil {
+		return err
+	}
+	tfhd, err := findPath(children, "tfhd")
+	if err != nil {
+		return err
+	}
+	if len(tfhd.payload) < 8 {
+		return fmt.Errorf("%w: truncated tfhd", errUnsupportedMP4)
+	}
+	id := binary.BigEndian.Uint32(tfhd.payload[4:8])
+
+	in.time = 0
+	if tfdt := findBox(children, "tfdt"); tfdt != nil {
+		decodeTime, err := readField(tfdt.payload, tfdtTime)
+		if err != nil {
+			return err
+		}
+		if timescale := in.trackTimescales[id]; timescale != 0 {
+			in.time = float64(decodeTime) / float64(timescale)
+		}
+	}
+	return nil
+}
+
+// rewriteMoof renumbers the tracks and the sequence of the fragment, and moves its base data offsets.
+func (in *mp4Input) rewriteMoof(sequence uint32, outPos int64) ([]byte, error) {
+	moof, err := parseBoxes(in.moof)
+	if err != nil {
+		return nil, err
+	}
+	for i := range moof {
+		switch moof[i].typ {
+		case "mfhd":
+			if len(moof[i].payload) < 8 {
+				return nil, fmt.Errorf("%w: truncated mfhd", errUnsupportedMP4)
+			}
+			binary.BigEndian.PutUint32(moof[i].payload[4:8], sequence)
+		case "traf":
+			traf, err := parseBoxes(moof[i].payload)
+			if err != nil {
+				return nil, err
+			}
+			tfhd := findBox(traf, "tfhd")
+			if tfhd == nil || len(tfhd.payload) < 8 {
+				return nil, fmt.Errorf("%w: invalid tfhd", errUnsupportedMP4)
+			}
+			id := binary.BigEndian.Uint32(tfhd.payload[4:8])
+			newID, ok := in.trackIDs[id]
+			if !ok {
+				return nil, fmt.Errorf("%w: fragment of unknown track %d", errUnsupportedMP4, id)
+			}
+			binary.BigEndian.PutUint32(tfhd.payload[4:8], newID)
+
+			const baseDataOffsetPresent = 0x1
+			flags := binary.BigEndian.Uint32(tfhd.payload[:4]) & 0xffffff
+			if flags&baseDataOffsetPresent != 0 {
+				if len(tfhd.payload) < 16 {
+					return nil, fmt.Errorf("%w: truncated tfhd", errUnsupportedMP4)
+				}
+				// The offset is absolute, so it has to move along with the fragment.
+				offset := int64(binary.BigEndian.Uint64(tfhd.payload[8:16])) - in.moofPos + outPos
+				binary.BigEndian.PutUint64(tfhd.payload[8:16], uint64(offset))
+			}
+			moof[i].payload = joinBoxes(traf)
+		}
+	}
+	return joinBoxes(moof), nil
+}
+
+// countingWriter keeps track of the position in the output.
+type countingWriter struct {
+	w   io.Writer
+	pos int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.pos += int64(n)
+	return n, err
+}
+
+// muxMP4 merges the tracks of the fragmented MP4 inputs into a single file written to w.
+// The first input is the primary one (its ftyp and movie header are kept), usually the video.
+func muxMP4(w io.Writer, readers ...io.Reader) error {
+	if len(readers) == 0 {
+		return fmt.Errorf("%w: nothing to mux", errUnsupportedMP4)
+	}
+
+	inputs := make([]*mp4Input, 0, len(readers))
+	var nextID uint32
+	for _, r := range readers {
+		in := &mp4Input{r: newMP4Reader(r)}
+		if err := in.readHeader(); err != nil {
+			return err
+		}
+		// Renumber the tracks so that they don't clash between the inputs.
+		if len(inputs) != 0 {
+			for id := range in.trackIDs {
+				in.trackIDs[id] = nextID + id
+			}
+		}
+		nextID = in.maxTrackID()
+		inputs = append(inputs, in)
+	}
+
+	moov, err := mergeMoov(inputs)
+	if err != nil {
+		return err
+	}
+
+	out := &countingWriter{w: w}
+	if ftyp := inputs[0].ftyp; ftyp != nil {
+		if err := ftyp.writeTo(out); err != nil {
+			return err
+		}
+	}
+	if err := (&mp4Box{typ: "moov", payload: moov}).writeTo(out); err != nil {
+		return err
+	}
+
+	for _, in := range inputs {
+		if err := in.nextFragment(); err != nil {
+			return err
+		}
+	}
+
+	for sequence := uint32(1); ; sequence++ {
+		// Interleave the fragments by their decode time.
+		var in *mp4Input
+		for _, candidate := range inputs {
+			if candidate.moof != nil && (in == nil || candidate.time < in.time) {
+				in = candidate
+			}
+		}
+		if in == nil {
+			return nil
+		}
+		if err := writeFragment(out, in, sequence); err != nil {
+			return err
+		}
+		if err := in.nextFragment(); err != nil {
+			return err
+		}
+	}
+}
+
+// writeFragment writes the current moof of the input along with its mdat.
+func writeFragment(out *countingWriter, in *mp4Input, sequence uint32) error {
+	moof, err := in.rewriteMoof(sequence, out.pos)
+	if err != nil {
+		return err
+	}
+	if err := (&mp4Box{typ: "moof", payload: moof}).writeTo(out); err != nil {
+		return err
+	}
+
+	for {
+		typ, size, err := in.r.next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("%w: moof without mdat", errUnsupportedMP4)
+			}
+			return err
+		}
+		if typ != "mdat" {
+			if err := in.r.skip(size); err != nil {
+				return err
+			}
+			continue
+		}
+		if size < 0 {
+			return fmt.Errorf("%w: mdat of unknown size", errUnsupportedMP4)
+		}
+		if err := writeBoxHeader(out, "mdat", size); err != nil {
+			return err
+		}
+		return in.r.copyPayload(out, size)
+	}
+}
+
+// mergeMoov builds the movie header of the output, with the tracks of all the inputs.
+func mergeMoov(inputs []*mp4Input) ([]byte, error) {
+	primary := inputs[0]
+	var (
+		mvhd   *mp4Box
+		traks  []mp4Box
+		mvex   []mp4Box
+		others []mp4Box
+		// duration is in the primary movie timescale.
+		duration uint64
+	)
+
+	for i, in := range inputs {
+		scale := func(v uint64) uint64 {
+			return v * primary.movieTimescale / in.movieTimescale
+		}
+
+		for _, box := range in.moov {
+			box := mp4Box{typ: box.typ, payload: append([]byte(nil), box.payload...)}
+			switch box.typ {
+			case "mvhd":
+				d, err := readField(box.payload, mvhdDuration)
+				if err != nil {
+					return nil, err
+				}
+				duration = max(duration, scale(d))
+				if i == 0 {
+					mvhd = &box
+				}
+			case "trak":
+				trak, err := in.rewriteTrak(box.payload, scale)
+				if err != nil {
+					return nil, err
+				}
+				traks = append(traks, mp4Box{typ: "trak", payload: trak})
+			case "mvex":
+				children, err := parseBoxes(box.payload)
+				if err != nil {
+					return nil, err
+				}
+				for _, child := range children {
+					switch child.typ {
+					case "trex":
+						if len(child.payload) < 8 {
+							return nil, fmt.Errorf("%w: truncated trex", errUnsupportedMP4)
+						}
+						id := binary.BigEndian.Uint32(child.payload[4:8])
+						binary.BigEndian.PutUint32(child.payload[4:8], in.trackIDs[id])
+						mvex = append(mvex, child)
+					case "mehd":
+						d, err := readField(child.payload, mehdDuration)
+						if err != nil {
+							return nil, err
+						}
+						duration = max(duration, scale(d))
+					}
+				}
+			default:
+				if i == 0 {
+					others = append(others, box)
+				}
+			}
+		}
+	}
+
+	if mvhd == nil {
+		return nil, fmt.Errorf("%w: missing mvhd", errUnsupportedMP4)
+	}
+	if err := writeField(mvhd.payload, mvhdDuration, 0); err != nil {
+		return nil, err
+	}
+	// next_track_ID is the last field of mvhd.
+	if len(mvhd.payload) < 4 {
+		return nil, fmt.Errorf("%w: truncated mvhd", errUnsupportedMP4)
+	}
+	binary.BigEndian.PutUint32(mvhd.payload[len(mvhd.payload)-4:], inputs[len(inputs)-1].maxTrackID()+1)
+
+	mehd := mp4Box{typ: "mehd", payload: make([]byte, 12)}
+	mehd.payload[0] = 1 // version 1, 64-bit duration
+	binary.BigEndian.PutUint64(mehd.payload[4:], duration)
+	mvex = append([]mp4Box{mehd}, mvex...)
+
+	boxes := append([]mp4Box{*mvhd}, traks...)
+	boxes = append(boxes, mp4Box{typ: "mvex", payload: joinBoxes(mvex)})
+	boxes = append(boxes, others...)
+
+	return joinBoxes(boxes), nil
+}
+
+// rewriteTrak sets the output track id, and scales the movie durations into the primary timescale.
+func (in *mp4Input) rewriteTrak(payload []byte, scale func(uint64) uint64) ([]byte, error) {
+	trak, err := parseBoxes(payload)
+	if err != nil {
+		return nil, err
+	}
+	for i := range trak {
+		switch trak[i].typ {
+		case "tkhd":
+			id, err := readField(trak[i].payload, tkhdTrackID)
+			if err != nil {
+				return nil, err
+			}
+			if err := writeField(trak[i].payload, tkhdTrackID, uint64(in.trackIDs[uint32(id)])); err != nil {
+				return nil, err
+			}
+			d, err := readField(trak[i].payload, tkhdDuration)
+			if err != nil {
+				return nil, err
+			}
+			if err := writeField(trak[i].payload, tkhdDuration, scale(d)); err != nil {
+				return nil, err
+			}
+		case "edts":
+			edts, err := parseBoxes(trak[i].payload)
+			if err != nil {
+				return nil, err
+			}
+			if elst := findBox(edts, "elst"); elst != nil {
+				if err := scaleEditList(elst.payload, scale); err != nil {
+					return nil, err
+				}
+			}
+			trak[i].payload = joinBoxes(edts)
+		}
+	}
+	return joinBoxes(trak), nil
+}
+
+// scaleEditList scales the segment durations of the edit list, the media times are in the media timescale.
+func scaleEditList(payload []byte, scale func(uint64) uint64) error {
+	if len(payload) < 8 {
+		return fmt.Errorf("%w: truncated elst", errUnsupportedMP4)
+	}
+	size, entrySize := 4, 12
+	if payload[0] == 1 {
+		size, entrySize = 8, 20
+	}
+	count := int(binary.BigEndian.Uint32(payload[4:8]))
+	if 8+count*entrySize > len(payload) {
+		return fmt.Errorf("%w: truncated elst", errUnsupportedMP4)
+	}
+	for i := 0; i < count; i++ {
+		entry := payload[8+i*entrySize:]
+		putUint(entry, size, scale(getUint(entry, size)))
+	}
+	return nil
+}
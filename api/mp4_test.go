@@ -0,0 +1,151 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testBox serializes a box with the given payload parts.
+func testBox(typ string, parts ...[]byte) []byte {
+	payload := bytes.Join(parts, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+8))
+	b = append(b, typ...)
+	return append(b, payload...)
+}
+
+// testFullBox serializes a version 0 full box, the fields are 32-bit values.
+func testFullBox(typ string, fields ...uint32) []byte {
+	payload := make([]byte, 4, 4+4*len(fields))
+	for _, f := range fields {
+		payload = binary.BigEndian.AppendUint32(payload, f)
+	}
+	return testBox(typ, payload)
+}
+
+type testFragment struct {
+	decodeTime uint64
+	data       string
+}
+
+// testMP4 builds a fragmented MP4 file with a single track.
+// If withBaseOffset is set, the fragments use absolute base data offsets.
+func testMP4(trackID, timescale uint32, withBaseOffset bool, fragments ...testFragment) []byte {
+	// mvhd: creation, modification, timescale, duration, 19 fields we don't care about, next_track_ID.
+	mvhd := testFullBox("mvhd", append([]uint32{0, 0, 1000, 0}, append(make([]uint32, 19), trackID+1)...)...)
+	tkhd := testFullBox("tkhd", append([]uint32{0, 0, trackID, 0, 0}, make([]uint32, 16)...)...)
+	mdhd := testFullBox("mdhd", 0, 0, timescale, 0, 0)
+	trak := testBox("trak", tkhd, testBox("mdia", mdhd))
+	mvex := testBox("mvex", testFullBox("trex", trackID, 1, 0, 0, 0))
+
+	file := testBox("ftyp", []byte("iso5\x00\x00\x02\x00iso6mp41"))
+	file = append(file, testBox("moov", mvhd, trak, mvex)...)
+	file = append(file, testBox("sidx", make([]byte, 24))...)
+
+	for i, fragment := range fragments {
+		tfdt := testBox("tfdt", []byte{1, 0, 0, 0}, binary.BigEndian.AppendUint64(nil, fragment.decodeTime))
+		tfhd := testFullBox("tfhd", trackID)
+		if withBaseOffset {
+			payload := []byte{0, 0, 0, 1}
+			payload = binary.BigEndian.AppendUint32(payload, trackID)
+			// The data starts right after the moof header, at the absolute position of the moof.
+			payload = binary.BigEndian.AppendUint64(payload, uint64(len(file)))
+			tfhd = testBox("tfhd", payload)
+		}
+		moof := testBox("moof", testFullBox("mfhd", uint32(i+1)), testBox("traf", tfhd, tfdt))
+		file = append(file, moof...)
+		file = append(file, testBox("mdat", []byte(fragment.data))...)
+	}
+
+	return file
+}
+
+func TestMuxMP4(t *testing.T) {
+	t.Parallel()
+	video := testMP4(1, 30, false,
+		testFragment{0, "video 0"}, testFragment{30, "video 1"}, testFragment{60, "video 2"})
+	audio := testMP4(1, 48000, true,
+		testFragment{0, "audio 0"}, testFragment{48000, "audio 1"}, testFragment{96000, "audio 2"})
+
+	var out bytes.Buffer
+	assert.NoError(t, muxMP4(&out, bytes.NewReader(video), bytes.NewReader(audio)))
+
+	boxes, err := parseBoxes(out.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, 2+6*2, len(boxes), "unexpected amount of boxes")
+	assert.Equal(t, "ftyp", boxes[0].typ)
+	assert.Equal(t, "moov", boxes[1].typ)
+
+	// The movie header has both tracks.
+	moov, err := parseBoxes(boxes[1].payload)
+	assert.NoError(t, err)
+	var ids []uint64
+	for _, box := range moov {
+		if box.typ == "trak" {
+			trak, err := parseBoxes(box.payload)
+			assert.NoError(t, err)
+			id, err := readField(findBox(trak, "tkhd").payload, tkhdTrackID)
+			assert.NoError(t, err)
+			ids = append(ids, id)
+		}
+	}
+	assert.Equal(t, []uint64{1, 2}, ids, "audio track should be renumbered")
+
+	mvex, err := findPath(moov, "mvex")
+	assert.NoError(t, err)
+	children, err := parseBoxes(mvex.payload)
+	assert.NoError(t, err)
+	var trex int
+	for _, box := range children {
+		if box.typ == "trex" {
+			trex++
+		}
+	}
+	assert.Equal(t, 2, trex, "each track should have trex")
+
+	// The fragments are interleaved by time, and renumbered.
+	wantData := []string{"video 0", "audio 0", "video 1", "audio 1", "video 2", "audio 2"}
+	var pos int64
+	for i := 0; i < 2; i++ {
+		pos += boxes[i].size()
+	}
+	for i, want := range wantData {
+		moofBox, mdat := boxes[2+i*2], boxes[3+i*2]
+		assert.Equal(t, "moof", moofBox.typ)
+		assert.Equal(t, "mdat", mdat.typ)
+		assert.Equal(t, want, string(mdat.payload))
+
+		moof, err := parseBoxes(moofBox.payload)
+		assert.NoError(t, err)
+		mfhd, err := findPath(moof, "mfhd")
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(i+1), binary.BigEndian.Uint32(mfhd.payload[4:8]), "unexpected sequence number")
+
+		tfhd, err := findPath(moof, "traf", "tfhd")
+		assert.NoError(t, err)
+		wantID := uint32(1 + i%2)
+		assert.Equal(t, wantID, binary.BigEndian.Uint32(tfhd.payload[4:8]), "unexpected track id")
+		if wantID == 2 {
+			offset := binary.BigEndian.Uint64(tfhd.payload[8:16])
+			assert.Equal(t, uint64(pos), offset, "base data offset should move with the fragment")
+		}
+		pos += moofBox.size() + mdat.size()
+	}
+}
+
+func TestMuxMP4Unsupported(t *testing.T) {
+	t.Parallel()
+	// A regular (not fragmented) file has no mvex.
+	mvhd := testFullBox("mvhd", append([]uint32{0, 0, 1000, 0}, make([]uint32, 20)...)...)
+	file := append(testBox("ftyp", []byte("isom")), testBox("moov", mvhd)...)
+	file = append(file, testBox("mdat", []byte("data"))...)
+
+	var out bytes.Buffer
+	err := muxMP4(&out, bytes.NewReader(file), bytes.NewReader(testMP4(1, 48000, false)))
+	assert.ErrorIs(t, err, errUnsupportedMP4)
+
+	err = muxMP4(&out, bytes.NewReader([]byte("<html>not a video</html>")))
+	assert.ErrorIs(t, err, errUnsupportedMP4)
+}
@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultRetryAfter is used on 429 responses without both Retry-After and rate limit headers.
+const defaultRetryAfter = 10 * time.Second
+
+// RateLimit is the request budget reported by reddit in the X-Ratelimit-* headers.
+type RateLimit struct {
+	// Reset is the time when the budget is going to be reset.
+	Reset time.Time
+	// Remaining is the amount of requests that can be made before the reset.
+	Remaining float64
+	// Used is the amount of requests made in the current period.
+	Used int
+}
+
+// Known reports whether reddit has reported any rate limits yet.
+func (r RateLimit) Known() bool {
+	return !r.Reset.IsZero()
+}
+
+// parseRateLimit returns the rate limit described by the headers, or false if they are not present.
+func parseRateLimit(h http.Header, now time.Time) (RateLimit, bool) {
+	remaining, err := strconv.ParseFloat(h.Get("X-Ratelimit-Remaining"), 64)
+	if err != nil {
+		return RateLimit{}, false
+	}
+	reset, err := strconv.ParseFloat(h.Get("X-Ratelimit-Reset"), 64)
+	if err != nil {
+		return RateLimit{}, false
+	}
+	used, _ := strconv.Atoi(h.Get("X-Ratelimit-Used"))
+
+	return RateLimit{
+		Reset:     now.Add(time.Duration(reset * float64(time.Second))),
+		Remaining: remaining,
+		Used:      used,
+	}, true
+}
+
+// parseRetryAfter parses the Retry-After header, which is either in seconds or an HTTP date.
+func parseRetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	value := h.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return date.Sub(now), true
+	}
+	return 0, false
+}
+
+// scheduler paces the requests, so that all of them fit in the rate limit budget.
+// It is shared between everything that uses the client.
+type scheduler struct {
+	mu sync.Mutex
+
+	limit RateLimit
+	// next is the earliest time the next request can be sent at.
+	next time.Time
+	// blockedUntil is set after a 429 response.
+	blockedUntil time.Time
+}
+
+// reserve returns the time at which the request is allowed to be sent, and books it.
+func (s *scheduler) reserve(now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at := now
+	if s.next.After(at) {
+		at = s.next
+	}
+	if s.blockedUntil.After(at) {
+		at = s.blockedUntil
+	}
+
+	if s.limit.Known() && at.Before(s.limit.Reset) {
+		if s.limit.Remaining < 1 {
+			// The budget is spent, nothing can be done until it's reset.
+			at = s.limit.Reset
+		} else {
+			// Spread the remaining budget evenly until the reset.
+			interval := s.limit.Reset.Sub(at) / time.Duration(s.limit.Remaining)
+			s.limit.Remaining--
+			s.next = at.Add(interval)
+			return at
+		}
+	}
+	s.next = at
+
+	return at
+}
+
+// wait blocks until the request is allowed to be sent, or the context is done.
+func (s *scheduler) wait(ctx context.Context) error {
+	return sleep(ctx, time.Until(s.reserve(time.Now())))
+}
+
+// update stores the rate limit reported in the response headers.
+func (s *scheduler) update(h http.Header, now time.Time) {
+	limit, ok := parseRateLimit(h, now)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	s.limit = limit
+	s.mu.Unlock()
+}
+
+// backoff blocks all requests after a 429 response, for as long as reddit asked.
+func (s *scheduler) backoff(h http.Header, now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d, ok := parseRetryAfter(h, now)
+	if !ok {
+		d = defaultRetryAfter
+		if s.limit.Known() && s.limit.Reset.After(now) {
+			d = s.limit.Reset.Sub(now)
+		}
+	}
+	if until := now.Add(d); until.After(s.blockedUntil) {
+		s.blockedUntil = until
+	}
+
+	return d
+}
+
+func (s *scheduler) rateLimit() RateLimit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limit
+}
+
+// RateLimit returns the last known request budget, shared by all the users of the client.
+func (c *Client) RateLimit() RateLimit {
+	return c.limiter.rateLimit()
+}
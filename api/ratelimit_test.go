@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	h := http.Header{}
+	h.Set("X-Ratelimit-Used", "3")
+	h.Set("X-Ratelimit-Remaining", "597.0")
+	h.Set("X-Ratelimit-Reset", "420")
+
+	limit, ok := parseRateLimit(h, now)
+	assert.True(t, ok)
+	assert.Equal(t, 3, limit.Used)
+	assert.Equal(t, 597.0, limit.Remaining)
+	assert.Equal(t, now.Add(420*time.Second), limit.Reset)
+
+	_, ok = parseRateLimit(http.Header{}, now)
+	assert.False(t, ok, "missing headers should not be parsed")
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	t.Parallel()
+	now := time.Now().Truncate(time.Second)
+
+	h := http.Header{}
+	h.Set("Retry-After", "5")
+	d, ok := parseRetryAfter(h, now)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, d)
+
+	h.Set("Retry-After", now.Add(time.Minute).UTC().Format(http.TimeFormat))
+	d, ok = parseRetryAfter(h, now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Minute, d)
+}
+
+func TestSchedulerPacing(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	s := &scheduler{limit: RateLimit{Reset: now.Add(10 * time.Second), Remaining: 10}}
+
+	// The budget is spread evenly until the reset.
+	assert.Equal(t, now, s.reserve(now))
+	assert.Equal(t, now.Add(time.Second), s.reserve(now))
+
+	// Nothing can be sent until the reset after the budget is spent.
+	s = &scheduler{limit: RateLimit{Reset: now.Add(10 * time.Second), Remaining: 0}}
+	assert.Equal(t, now.Add(10*time.Second), s.reserve(now))
+}
+
+func TestTooManyRequests(t *testing.T) {
+	t.Parallel()
+	var requests atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/r/wallpaper/", func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("X-Ratelimit-Used", "2")
+		w.Header().Set("X-Ratelimit-Remaining", "98")
+		w.Header().Set("X-Ratelimit-Reset", "600")
+		w.Header().Set("Content-Type", "application/json")
+		b, err := os.ReadFile("testdata/sample.json")
+		assert.NoError(t, err)
+		_, err = w.Write(b)
+		assert.NoError(t, err)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	c := DefaultClient().WithBaseURL(u)
+
+	start := time.Now()
+	posts, _, err := c.Subreddit.GetPosts(context.TODO(), &RequestOptions{Subreddit: "wallpaper", Sorting: "best"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(posts), "unexpected decoded response")
+	assert.Equal(t, int32(2), requests.Load(), "request should be repeated after 429")
+	assert.GreaterOrEqual(t, time.Since(start), time.Second, "Retry-After was not respected")
+
+	limit := c.RateLimit()
+	assert.True(t, limit.Known())
+	assert.Equal(t, 98.0, limit.Remaining)
+	assert.Equal(t, 2, limit.Used)
+}
+
+func TestTooManyRequestsNotRetried(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	c := DefaultClient().WithBaseURL(u).WithRetryPolicy(RetryPolicy{RetryStatuses: []string{"5xx"}, MaxAttempts: 4})
+
+	_, _, err = c.Subreddit.GetPosts(context.TODO(), &RequestOptions{Subreddit: "wallpaper", Sorting: "best"})
+	assert.Error(t, err)
+
+	// The other requests wait for the rate limit window, even though this one isn't repeated.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, c.limiter.wait(ctx), context.DeadlineExceeded, "429 should back off the following requests")
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -15,17 +16,26 @@ const (
 	defaultBaseURL      = "https://reddit.com"
 	defaultBaseVideoURL = "https://v.redd.it"
 	defaultBaseImageURL = "https://i.redd.it"
+	defaultUserAgent    = "go:redditdl (https://github.com/handsomefox/redditdl)"
 )
 
 // This is the client used to make requests in RedditStreamer.Stream().
 type Client struct {
 	Subreddit *SubredditService
 
-	client *http.Client
+	client  *http.Client
+	auth    *authenticator
+	limiter *scheduler
+	retry   RetryPolicy
+	video   VideoOptions
 
-	base    *url.URL
-	imgbase *url.URL
-	vidbase *url.URL
+	base      *url.URL
+	imgbase   *url.URL
+	vidbase   *url.URL
+	authbase  *url.URL
+	oauthbase *url.URL
+
+	userAgent string
 }
 
 func (c *Client) WithTimeout(timeout time.Duration) *Client {
@@ -48,12 +58,55 @@ func (c *Client) WithBaseImageURL(u *url.URL) *Client {
 	return c
 }
 
+func (c *Client) WithAuthURL(u *url.URL) *Client {
+	c.authbase = u
+	return c
+}
+
+func (c *Client) WithOAuthURL(u *url.URL) *Client {
+	c.oauthbase = u
+	return c
+}
+
+func (c *Client) WithUserAgent(userAgent string) *Client {
+	if userAgent != "" {
+		c.userAgent = userAgent
+	}
+	return c
+}
+
+// WithCredentials makes the client authenticate using OAuth2,
+// listing requests are then sent to the OAuth base url with a bearer token.
+func (c *Client) WithCredentials(creds Credentials) *Client {
+	c.auth = &authenticator{creds: creds}
+	return c
+}
+
+// WithRetryPolicy sets the policy used to retry the requests that failed with a transient error.
+func (c *Client) WithRetryPolicy(policy RetryPolicy) *Client {
+	c.retry = policy
+	return c
+}
+
 type RequestOptions struct {
 	After     string
 	Sorting   string
 	Timeframe string
 	Subreddit string
-	Count     int64
+	// User requests a listing of the user instead of a subreddit listing.
+	User string
+	// UserListing is the listing of the User: "submitted" (the default), "saved" or "upvoted".
+	UserListing string
+	// Kind filters the items of the user listings: "links" or "comments", both if it's empty.
+	Kind string
+	// Multireddit requests the listing of a multireddit, in the "{user}/{multireddit}" format.
+	Multireddit string
+	// Query requests the search results for the query, restricted to the Subreddit if it's set.
+	Query string
+	// SearchType is the type of the search results: "link", "sr" or "user", or a comma-separated list of them.
+	// Only the posts (type=link) are searched if it's empty.
+	SearchType string
+	Count      int64
 }
 
 func (c *Client) Do(ctx context.Context, opts *RequestOptions, method string, body io.Reader) (*http.Response, error) {
@@ -62,23 +115,140 @@ func (c *Client) Do(ctx context.Context, opts *RequestOptions, method string, bo
 	}
 	url := c.optsURL(opts)
 
-	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	req, err := c.newRequest(ctx, method, url, body)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("User-Agent", "go:getter")
 
-	return c.client.Do(req)
+	return c.do(req, true)
+}
+
+// do sends the request, retrying it on transient errors according to the retry policy.
+// Requests to the reddit api (api=true) are also authorized and paced by the rate limits.
+func (c *Client) do(req *http.Request, api bool) (*http.Response, error) {
+	var res *http.Response
+	err := c.retry.Do(req.Context(), func(attempt int) error {
+		if attempt > 1 {
+			if err := rewindBody(req); err != nil {
+				return err
+			}
+		}
+		var err error
+		res, err = c.attempt(req, api)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// attempt sends the request once, responses with a transient status are returned as a *StatusError.
+func (c *Client) attempt(req *http.Request, api bool) (*http.Response, error) {
+	var (
+		res *http.Response
+		err error
+	)
+	if api {
+		res, err = c.send(req)
+	} else {
+		res, err = c.client.Do(req)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The rate limit is respected by all the requests, the retry policy only decides whether to repeat this one.
+	var retryAfter time.Duration
+	if api && res.StatusCode == http.StatusTooManyRequests {
+		retryAfter = c.limiter.backoff(res.Header, time.Now())
+	}
+	if !c.retry.retryableStatus(res.StatusCode) {
+		return res, nil
+	}
+	defer res.Body.Close()
+
+	statusErr := newStatusError(res)
+	statusErr.RetryAfter = retryAfter
+
+	return nil, statusErr
+}
+
+// send sends a single request to the reddit api.
+// It waits for the rate limit budget, authorizes the request and repeats it once if the token was revoked.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	for reauthorized := false; ; reauthorized = true {
+		if err := c.authorize(req); err != nil {
+			return nil, err
+		}
+		if err := c.limiter.wait(req.Context()); err != nil {
+			return nil, err
+		}
+
+		res, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		c.limiter.update(res.Header, time.Now())
+
+		if res.StatusCode != http.StatusUnauthorized || !c.Authenticated() || reauthorized {
+			return res, nil
+		}
+		// The token might have been revoked before it expired, try again with a fresh one.
+		if err := rewindBody(req); err != nil {
+			return res, nil //nolint:nilerr // the request can't be repeated, the response is still usable.
+		}
+		res.Body.Close()
+		c.invalidateToken()
+	}
+}
+
+func (c *Client) authorize(req *http.Request) error {
+	if !c.Authenticated() {
+		return nil
+	}
+	token, err := c.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "bearer "+token.AccessToken)
+	return nil
+}
+
+// rewindBody prepares the request body to be sent again.
+func rewindBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	if req.GetBody == nil {
+		return fmt.Errorf("request body can not be rewound")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+	return nil
 }
 
 func (c *Client) GetURL(ctx context.Context, surl string) (*http.Response, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, surl, http.NoBody)
+	req, err := c.newRequest(ctx, http.MethodGet, surl, http.NoBody)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("User-Agent", "go:getter")
 
-	return c.client.Do(req)
+	return c.do(req, false)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, surl string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, surl, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("User-Agent", c.userAgent)
+
+	return req, nil
 }
 
 func (c *Client) GetImageByURL(ctx context.Context, surl string) (*http.Response, error) {
@@ -108,12 +278,62 @@ func (c *Client) GetVideoByURL(ctx context.Context, surl string) (*http.Response
 }
 
 func (c *Client) optsURL(opts *RequestOptions) string {
-	u := c.base.
-		JoinPath("r").
-		JoinPath(opts.Subreddit).
-		JoinPath(opts.Sorting + ".json")
+	base := c.base
+	if c.Authenticated() {
+		base = c.oauthbase
+	}
+
+	var u *url.URL
+	switch {
+	case opts.Query != "" && opts.Subreddit != "":
+		u = base.
+			JoinPath("r").
+			JoinPath(opts.Subreddit).
+			JoinPath("search.json")
+	case opts.Query != "":
+		u = base.JoinPath("search.json")
+	case opts.Multireddit != "":
+		user, name, _ := strings.Cut(opts.Multireddit, "/")
+		u = base.
+			JoinPath("user").
+			JoinPath(user).
+			JoinPath("m").
+			JoinPath(name).
+			JoinPath(opts.Sorting + ".json")
+	case opts.User != "":
+		listing := opts.UserListing
+		if listing == "" {
+			listing = "submitted"
+		}
+		u = base.
+			JoinPath("user").
+			JoinPath(opts.User).
+			JoinPath(listing + ".json")
+	default:
+		u = base.
+			JoinPath("r").
+			JoinPath(opts.Subreddit).
+			JoinPath(opts.Sorting + ".json")
+	}
 
 	values := u.Query()
+	if opts.Query != "" {
+		values.Add("q", opts.Query)
+		searchType := opts.SearchType
+		if searchType == "" {
+			searchType = "link"
+		}
+		values.Add("type", searchType)
+		if opts.Subreddit != "" {
+			values.Add("restrict_sr", "1")
+		}
+	}
+	if opts.Query != "" || opts.User != "" {
+		values.Add("sort", opts.Sorting)
+	}
+	if opts.User != "" && opts.Kind != "" {
+		values.Add("type", opts.Kind)
+	}
 	values.Add("after", opts.After)
 	values.Add("limit", fmt.Sprint(opts.Count))
 	values.Add("t", opts.Timeframe)
@@ -135,10 +355,20 @@ func (c *Client) BaseVideoURL() *url.URL {
 	return c.vidbase
 }
 
+func (c *Client) AuthURL() *url.URL {
+	return c.authbase
+}
+
+func (c *Client) OAuthURL() *url.URL {
+	return c.oauthbase
+}
+
 func DefaultClient() *Client {
 	baseURL, _ := url.Parse(defaultBaseURL)
 	basevidURL, _ := url.Parse(defaultBaseVideoURL)
 	baseimgURL, _ := url.Parse(defaultBaseImageURL)
+	authURL, _ := url.Parse(defaultAuthURL)
+	oauthURL, _ := url.Parse(defaultOAuthURL)
 	c := &Client{
 		client: &http.Client{
 			Transport: &http.Transport{
@@ -146,9 +376,14 @@ func DefaultClient() *Client {
 			},
 			Timeout: clientTimeout,
 		},
-		base:    baseURL,
-		imgbase: baseimgURL,
-		vidbase: basevidURL,
+		limiter:   &scheduler{},
+		retry:     DefaultRetryPolicy(),
+		base:      baseURL,
+		imgbase:   baseimgURL,
+		vidbase:   basevidURL,
+		authbase:  authURL,
+		oauthbase: oauthURL,
+		userAgent: defaultUserAgent,
 	}
 	c.Subreddit = &SubredditService{
 		client: c,
@@ -40,6 +40,47 @@ func TestURLFormatting(t *testing.T) {
 	assert.Equal(t, correct, res, "incorrect url format")
 }
 
+func TestUserURLFormatting(t *testing.T) {
+	t.Parallel()
+	const correct = "https://reddit.com/user/example/submitted.json?after=t3_abc&limit=100&sort=top&t=week"
+	opts := &RequestOptions{
+		After:     "t3_abc",
+		Count:     100,
+		Sorting:   "top",
+		Timeframe: "week",
+		User:      "example",
+	}
+	assert.Equal(t, correct, DefaultClient().optsURL(opts), "incorrect url format")
+}
+
+func TestSearchURLFormatting(t *testing.T) {
+	t.Parallel()
+	c := DefaultClient()
+	opts := &RequestOptions{Count: 100, Sorting: "new", Timeframe: "all", Query: "mountain lake"}
+	assert.Equal(t, "https://reddit.com/search.json?after=&limit=100&q=mountain+lake&sort=new&t=all&type=link",
+		c.optsURL(opts), "incorrect url format")
+
+	opts.Subreddit = "wallpaper"
+	assert.Equal(t, "https://reddit.com/r/wallpaper/search.json?after=&limit=100&q=mountain+lake&restrict_sr=1&sort=new&t=all&type=link",
+		c.optsURL(opts), "incorrect restricted url format")
+
+	opts.SearchType = "link,sr"
+	assert.Equal(t, "https://reddit.com/r/wallpaper/search.json?after=&limit=100&q=mountain+lake&restrict_sr=1&sort=new&t=all&type=link%2Csr",
+		c.optsURL(opts), "incorrect search type")
+}
+
+func TestMultiredditURLFormatting(t *testing.T) {
+	t.Parallel()
+	c := DefaultClient()
+	opts := &RequestOptions{Count: 100, Sorting: "top", Timeframe: "all", Multireddit: "example/wallpapers"}
+	assert.Equal(t, "https://reddit.com/user/example/m/wallpapers/top.json?after=&limit=100&t=all",
+		c.optsURL(opts), "incorrect url format")
+
+	opts = &RequestOptions{Count: 100, Sorting: "top", Timeframe: "all", Subreddit: "wallpaper+wallpapers"}
+	assert.Equal(t, "https://reddit.com/r/wallpaper+wallpapers/top.json?after=&limit=100&t=all",
+		c.optsURL(opts), "incorrect combined subreddits url format")
+}
+
 func TestGetURL(t *testing.T) {
 	t.Parallel()
 	var (
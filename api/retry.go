@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// RetryPolicy describes how the requests that failed with a transient error are retried.
+type RetryPolicy struct {
+	// RetryStatuses are the response statuses that are considered transient.
+	// Each one is either an exact status code ("429") or a class of them ("5xx").
+	RetryStatuses []string
+	// MaxAttempts is the total amount of attempts, including the first one.
+	MaxAttempts int
+	// BaseDelay is the delay after the first attempt, it doubles after each one.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between the attempts.
+	MaxDelay time.Duration
+	// Jitter is the fraction of the delay that is randomized, from 0 to 1.
+	Jitter float64
+}
+
+// DefaultRetryPolicy returns the policy used by the DefaultClient.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		RetryStatuses: []string{"5xx", "408", "429"},
+		MaxAttempts:   4,
+		BaseDelay:     500 * time.Millisecond,
+		MaxDelay:      30 * time.Second,
+		Jitter:        0.2,
+	}
+}
+
+// Validate checks that the policy values make sense.
+func (p RetryPolicy) Validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("max attempts must be at least 1, got %d", p.MaxAttempts)
+	}
+	if p.BaseDelay < 0 || p.MaxDelay < 0 {
+		return fmt.Errorf("retry delays can not be negative")
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("jitter must be between 0 and 1, got %v", p.Jitter)
+	}
+	for _, status := range p.RetryStatuses {
+		if _, _, err := parseStatusPattern(status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Do calls fn until it succeeds, fails with an error that is not transient, or the attempts run out.
+// The returned error wraps the errors of all the attempts.
+func (p RetryPolicy) Do(ctx context.Context, fn func(attempt int) error) error {
+	var errs []error
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+
+		if attempt >= p.MaxAttempts || !p.Retryable(err) || ctx.Err() != nil {
+			return newRetryError(errs)
+		}
+
+		delay := p.Delay(attempt, err)
+		log.Debug().
+			Err(err).
+			Int("attempt", attempt).
+			Int("max_attempts", p.MaxAttempts).
+			Dur("delay", delay).
+			Msg("retrying after a transient error")
+
+		if err := sleep(ctx, delay); err != nil {
+			return newRetryError(append(errs, err))
+		}
+	}
+}
+
+// Retryable reports whether the error is transient according to the policy.
+func (p RetryPolicy) Retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return p.retryableStatus(statusErr.StatusCode)
+	}
+	var partialErr *partialWriteError
+	if errors.Is(err, context.Canceled) || errors.As(err, &partialErr) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, errRangeNotSatisfiable) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+// Delay returns the delay after the given attempt.
+// The server-requested delay (Retry-After) takes priority over the policy, if it is longer.
+func (p RetryPolicy) Delay(attempt int, err error) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * p.Jitter * float64(delay)) //nolint:gosec // no need for crypto.
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+		delay = statusErr.RetryAfter
+	}
+
+	return delay
+}
+
+func (p RetryPolicy) retryableStatus(code int) bool {
+	for _, status := range p.RetryStatuses {
+		from, to, err := parseStatusPattern(status)
+		if err == nil && code >= from && code <= to {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStatusPattern returns the range of status codes matched by a pattern like "429" or "5xx".
+func parseStatusPattern(pattern string) (from, to int, err error) {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if len(pattern) == 3 && strings.HasSuffix(pattern, "xx") {
+		class, err := strconv.Atoi(pattern[:1])
+		if err == nil && class >= 1 && class <= 5 {
+			return class * 100, class*100 + 99, nil
+		}
+	}
+	code, err := strconv.Atoi(pattern)
+	if err != nil || code < 100 || code > 599 {
+		return 0, 0, fmt.Errorf("invalid status pattern: %q", pattern)
+	}
+	return code, code, nil
+}
+
+// RetryError is returned when all the attempts have failed.
+type RetryError struct {
+	Errs []error
+}
+
+func newRetryError(errs []error) error {
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return &RetryError{Errs: errs}
+}
+
+func (e *RetryError) Error() string {
+	msgs := make([]string, 0, len(e.Errs))
+	for _, err := range e.Errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("failed after %d attempts: [%s]", len(e.Errs), strings.Join(msgs, "; "))
+}
+
+func (e *RetryError) Unwrap() []error {
+	return e.Errs
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRetryPolicy() RetryPolicy {
+	policy := DefaultRetryPolicy()
+	policy.BaseDelay = time.Millisecond
+	policy.MaxDelay = 5 * time.Millisecond
+	return policy
+}
+
+func TestRetryPolicyValidate(t *testing.T) {
+	t.Parallel()
+	assert.NoError(t, DefaultRetryPolicy().Validate())
+
+	policy := DefaultRetryPolicy()
+	policy.RetryStatuses = []string{"6xx"}
+	assert.Error(t, policy.Validate())
+
+	policy = DefaultRetryPolicy()
+	policy.MaxAttempts = 0
+	assert.Error(t, policy.Validate())
+}
+
+func TestRetryableStatus(t *testing.T) {
+	t.Parallel()
+	policy := DefaultRetryPolicy()
+	assert.True(t, policy.retryableStatus(http.StatusServiceUnavailable))
+	assert.True(t, policy.retryableStatus(http.StatusTooManyRequests))
+	assert.False(t, policy.retryableStatus(http.StatusNotFound))
+	assert.False(t, policy.retryableStatus(http.StatusOK))
+}
+
+func TestRetryDelay(t *testing.T) {
+	t.Parallel()
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 3 * time.Second}
+	assert.Equal(t, time.Second, policy.Delay(1, nil))
+	assert.Equal(t, 2*time.Second, policy.Delay(2, nil))
+	assert.Equal(t, 3*time.Second, policy.Delay(3, nil), "delay should be capped")
+	assert.Equal(t, time.Minute, policy.Delay(1, &StatusError{RetryAfter: time.Minute}), "Retry-After should win")
+
+	policy.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		d := policy.Delay(1, nil)
+		assert.True(t, d > time.Second/2 && d <= time.Second, "jitter is out of bounds: %s", d)
+	}
+}
+
+func TestRetryTransientStatus(t *testing.T) {
+	t.Parallel()
+	var requests atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/r/wallpaper/", func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		b, err := os.ReadFile("testdata/sample.json")
+		assert.NoError(t, err)
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write(b)
+		assert.NoError(t, err)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	c := DefaultClient().WithBaseURL(u).WithRetryPolicy(testRetryPolicy())
+
+	posts, _, err := c.Subreddit.GetPosts(context.TODO(), &RequestOptions{Subreddit: "wallpaper", Sorting: "best"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(posts), "unexpected decoded response")
+	assert.Equal(t, int32(3), requests.Load())
+}
+
+func TestRetryExhausted(t *testing.T) {
+	t.Parallel()
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	c := DefaultClient().WithRetryPolicy(testRetryPolicy())
+	_, err := c.GetURL(context.TODO(), server.URL)
+
+	var retryErr *RetryError
+	assert.ErrorAs(t, err, &retryErr)
+	assert.Equal(t, 4, len(retryErr.Errs), "all attempt errors should be wrapped")
+	assert.Equal(t, int32(4), requests.Load())
+
+	var statusErr *StatusError
+	assert.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusInternalServerError, statusErr.StatusCode)
+}
+
+func TestRetryPermanentError(t *testing.T) {
+	t.Parallel()
+	var calls int
+	err := testRetryPolicy().Do(context.TODO(), func(int) error {
+		calls++
+		return errors.New("permanent")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls, "permanent errors should not be retried")
+
+	calls = 0
+	err = testRetryPolicy().Do(context.TODO(), func(int) error {
+		calls++
+		if calls < 2 {
+			return io.ErrUnexpectedEOF
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls, "broken transfers should be retried")
+}
@@ -3,8 +3,9 @@ package api
 import (
 	"context"
 	"encoding/json"
-	"io"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -12,17 +13,21 @@ type SubredditService struct {
 	client *Client
 }
 
+// Item describes the media of a post, use SubredditService.Download to fetch it.
 type Item struct {
-	Name        string
-	Extension   string
-	URL         string
+	Name      string
+	Extension string
+	URL       string
+	// ManifestURL is the DASH manifest of reddit videos, the video is downloaded with audio from it.
+	// URL is used as the fallback, if the manifest can't be used.
+	ManifestURL string
 	Orientation string
 	Type        string
 
-	Bytes []byte
-
 	Width  int
 	Height int
+	// Index is the position of the image in a gallery, starting from 1, or 0 for other posts.
+	Index int
 
 	IsOver18 bool
 }
@@ -34,6 +39,10 @@ func (s *SubredditService) GetPosts(ctx context.Context, opts *RequestOptions) (
 	}
 	defer res.Body.Close()
 
+	if err := checkResponse(res, jsonContentTypes); err != nil {
+		return nil, "", err
+	}
+
 	var ps Posts
 	if err := json.NewDecoder(res.Body).Decode(&ps); err != nil {
 		return nil, "", err
@@ -43,6 +52,7 @@ func (s *SubredditService) GetPosts(ctx context.Context, opts *RequestOptions) (
 }
 
 // GetItems return a slice of items, "after" string (consult reddit api), or an error.
+// Gallery posts are expanded into an item per image.
 func (s *SubredditService) GetItems(ctx context.Context, opts *RequestOptions) ([]Item, string, error) {
 	posts, after, err := s.GetPosts(ctx, opts)
 	if err != nil {
@@ -52,33 +62,71 @@ func (s *SubredditService) GetItems(ctx context.Context, opts *RequestOptions) (
 	items := make([]Item, 0, len(posts))
 	for _, p := range posts {
 		p := p
-		item, err := s.PostToItem(ctx, &p)
+		postItems, err := s.PostToItems(ctx, &p)
 		if err != nil {
 			return nil, after, err
 		}
-		items = append(items, *item)
+		for _, item := range postItems {
+			items = append(items, *item)
+		}
 	}
 
 	return items, after, nil
 }
 
+// PostToItems describes the media of the post, an item per image for galleries.
+// Nothing is fetched until the items are downloaded.
+func (s *SubredditService) PostToItems(ctx context.Context, p *Post) ([]*Item, error) {
+	gallery := p.Gallery()
+	if len(gallery) == 0 {
+		item, err := s.PostToItem(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		return []*Item{item}, nil
+	}
+
+	items := make([]*Item, 0, len(gallery))
+	for i := range gallery {
+		image := &gallery[i]
+		item, err := newItem(p, image.URL)
+		if err != nil {
+			return nil, err
+		}
+		// {post_id}_{index} keeps the gallery images together and in order.
+		item.Name = fmt.Sprintf("%s_%02d", p.Data.ID, image.Index)
+		item.Index = image.Index
+		item.Width, item.Height = image.Width, image.Height
+		item.Orientation = image.Orientation()
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// PostToItem describes the media of the post, nothing is fetched until the item is downloaded.
+// For galleries, only the first image is described, use PostToItems to get all of them.
 func (s *SubredditService) PostToItem(ctx context.Context, p *Post) (*Item, error) {
-	res, err := s.client.GetURL(ctx, p.URL())
-	if err != nil {
-		return nil, err
+	if gallery := p.Gallery(); len(gallery) != 0 {
+		items, err := s.PostToItems(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		return items[0], nil
 	}
-	defer res.Body.Close()
+	return newItem(p, p.URL())
+}
 
-	b, err := io.ReadAll(res.Body)
+func newItem(p *Post, surl string) (*Item, error) {
+	u, err := url.Parse(surl)
 	if err != nil {
 		return nil, err
 	}
 
 	item := Item{
-		Bytes:       b,
 		Name:        p.Title(),
 		Extension:   "",
-		URL:         p.URL(),
+		URL:         surl,
 		Orientation: p.Orientation(),
 		Type:        p.Type(),
 		Width:       p.Width(),
@@ -89,6 +137,9 @@ func (s *SubredditService) PostToItem(ctx context.Context, p *Post) (*Item, erro
 	switch p.Type() {
 	case "video":
 		item.Extension = "mp4"
+		if video := p.Video(); video != nil {
+			item.ManifestURL = strings.ReplaceAll(video.DashURL, "&amp;", "&")
+		}
 	case "image":
 		item.Extension = "jpg"
 	case "text":
@@ -97,7 +148,7 @@ func (s *SubredditService) PostToItem(ctx context.Context, p *Post) (*Item, erro
 		item.Extension = "bin"
 	}
 
-	split := strings.Split(res.Request.URL.Path, ".")
+	split := strings.Split(u.Path, ".")
 	if len(split) == 2 {
 		item.Extension = split[1]
 		item.Name = split[0][1:] // Skip the forward slash at the start
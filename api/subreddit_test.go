@@ -16,7 +16,7 @@ func TestGetPosts(t *testing.T) {
 	t.Parallel()
 	mux := http.NewServeMux()
 	mux.HandleFunc("/r/wallpaper/", func(w http.ResponseWriter, r *http.Request) {
-		r.Header.Add("content-type", "application/json")
+		w.Header().Set("Content-Type", "application/json")
 
 		b, err := os.ReadFile("testdata/sample.json")
 		assert.NoError(t, err)
@@ -49,7 +49,7 @@ func TestGetItems(t *testing.T) {
 	t.Parallel()
 	mux := http.NewServeMux()
 	mux.HandleFunc("/r/wallpaper/", func(w http.ResponseWriter, r *http.Request) {
-		r.Header.Add("content-type", "application/json")
+		w.Header().Set("Content-Type", "application/json")
 
 		b, err := os.ReadFile("testdata/sample.json")
 		assert.NoError(t, err)
@@ -88,3 +88,63 @@ func TestGetItems(t *testing.T) {
 	assert.Equal(t, "https://i.redd.it/05sk8tzriboa1.png", item.URL, "unexpected url")
 	assert.Equal(t, "image", item.Type, "unexpected type")
 }
+
+func TestGetPostsErrors(t *testing.T) {
+	t.Parallel()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/r/html/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, err := w.Write([]byte("<html></html>"))
+		assert.NoError(t, err)
+	})
+	mux.HandleFunc("/r/banned/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/r/private/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	client := DefaultClient().WithBaseURL(u)
+
+	tests := map[string]error{
+		"html":    ErrUnexpectedContentType,
+		"banned":  ErrNotFound,
+		"private": ErrForbidden,
+	}
+	for subreddit, want := range tests {
+		_, _, err := client.Subreddit.GetPosts(context.TODO(), &RequestOptions{Subreddit: subreddit, Sorting: "best"})
+		assert.ErrorIs(t, err, want, "subreddit=%s", subreddit)
+	}
+}
+
+func TestPostToItemsGallery(t *testing.T) {
+	t.Parallel()
+	p := GetSavedGallery(t)
+
+	items, err := DefaultClient().Subreddit.PostToItems(context.TODO(), &p)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(items), "unexpected amount of gallery items")
+
+	assert.Equal(t, "12abcde_01", items[0].Name, "unexpected name")
+	assert.Equal(t, "png", items[0].Extension, "unexpected extension")
+	assert.Equal(t, "portrait", items[0].Orientation, "unexpected orientation")
+
+	assert.Equal(t, "12abcde_02", items[1].Name, "unexpected name")
+	assert.Equal(t, "jpg", items[1].Extension, "unexpected extension")
+	assert.Equal(t, 3840, items[1].Width, "unexpected width")
+	assert.Equal(t, "landscape", items[1].Orientation, "unexpected orientation")
+
+	assert.Equal(t, "12abcde_04", items[2].Name, "unexpected name")
+	assert.Equal(t, "gif", items[2].Extension, "unexpected extension")
+	assert.Equal(t, 4, items[2].Index, "unexpected index")
+
+	p = GetSavedPost(t)
+	items, err = DefaultClient().Subreddit.PostToItems(context.TODO(), &p)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(items), "regular posts have a single item")
+	assert.Equal(t, 0, items[0].Index)
+}
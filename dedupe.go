@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Deduplication modes of the files, whose content was already saved.
+const (
+	DedupeOff      = "off"
+	DedupeSkip     = "skip"
+	DedupeHardlink = "hardlink"
+	DedupeSymlink  = "symlink"
+)
+
+// deduplicate looks up the content of the saved file in the hash index, and returns the path
+// (relative to the working directory) of the file with the same content, if there is one.
+// Depending on the mode, the new file is removed, or replaced with a link to the existing one.
+// New content is added to the index.
+//
+// The index is keyed by the hash of the downloaded data: it's called before the metadata is embedded,
+// so the hash of the file on disk may differ from its key on purpose, the downloads of the same media still match.
+func (s *Saver) deduplicate(wd, path string) (string, bool) {
+	if s.args.Dedupe == DedupeOff || s.args.Dedupe == "" || s.history == nil {
+		return "", false
+	}
+
+	hash, err := hashFile(path)
+	if err != nil {
+		log.Err(err).Str("path", path).Msg("failed to hash the file")
+		return "", false
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil {
+		log.Err(err).Str("path", path).Msg("failed to resolve the path of the file")
+		return "", false
+	}
+
+	// Two workers could save the same content at the same time.
+	s.dedupeMu.Lock()
+	defer s.dedupeMu.Unlock()
+
+	existing, err := s.history.File(hash)
+	if err != nil {
+		log.Err(err).Str("path", path).Msg("failed to look up the file hash")
+		return "", false
+	}
+	if existing == "" || existing == rel || !FileExists(filepath.Join(wd, existing)) {
+		if err := s.history.AddFile(hash, rel); err != nil {
+			log.Err(err).Str("path", path).Msg("failed to add the file hash")
+		}
+		return "", false
+	}
+
+	if err := replaceDuplicate(s.args.Dedupe, filepath.Join(wd, existing), path); err != nil {
+		log.Err(err).Str("path", path).Str("original", existing).Msg("failed to deduplicate the file, keeping it")
+		return "", false
+	}
+	log.Debug().Str("path", path).Str("original", existing).Str("mode", s.args.Dedupe).Msg("deduplicated the file")
+
+	return existing, true
+}
+
+// indexExistingFiles adds the hashes of the files that are in the working directory to the hash index,
+// so that the downloads are deduplicated against them too. It's only done once per history,
+// the files saved later are indexed as they are saved.
+func (s *Saver) indexExistingFiles(wd string) error {
+	indexed, err := s.history.FilesIndexed()
+	if err != nil || indexed {
+		return err
+	}
+
+	log.Info().Str("dir", wd).Msg("indexing the existing files for deduplication")
+	files := make(map[string]string)
+	err = filepath.WalkDir(wd, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".redditdl" {
+			return filepath.SkipDir
+		}
+		// Links point at the indexed files, the temporary files are incomplete.
+		if !d.Type().IsRegular() || isTemporary(path) {
+			return nil
+		}
+		hash, err := hashFile(path)
+		if err != nil {
+			log.Err(err).Str("path", path).Msg("failed to hash the file")
+			return nil
+		}
+		rel, err := filepath.Rel(wd, path)
+		if err != nil {
+			return err
+		}
+		if _, ok := files[hash]; !ok {
+			files[hash] = rel
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().Int("files", len(files)).Msg("indexed the existing files")
+	return s.history.IndexFiles(files)
+}
+
+// isTemporary reports whether the file is a partial download, or a file being written.
+func isTemporary(path string) bool {
+	for _, suffix := range []string{".part", ".part.json", ".tmp", ".link"} {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceDuplicate removes the duplicate, or replaces it with a link to the original.
+// The link is created next to the duplicate first, so the file is kept if linking fails.
+func replaceDuplicate(mode, original, duplicate string) error {
+	tmp := duplicate + ".link"
+	switch mode {
+	case DedupeSkip:
+		return os.Remove(duplicate)
+	case DedupeHardlink:
+		if err := os.Link(original, tmp); err != nil {
+			return err
+		}
+	case DedupeSymlink:
+		target, err := filepath.Rel(filepath.Dir(duplicate), original)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(target, tmp); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp, duplicate); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// hashFile returns the hex-encoded SHA-256 hash of the file content.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
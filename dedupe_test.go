@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/handsomefox/redditdl/api"
+	"github.com/handsomefox/redditdl/history"
+	"github.com/handsomefox/redditdl/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeduplicate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, err := w.Write([]byte("the same image"))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	post := func(name, subreddit string) *stream.Result {
+		var p api.Post
+		p.Data.Name = name
+		p.Data.Subreddit = subreddit
+		p.Data.PostHint = "image"
+		p.Data.URL = server.URL + "/" + name + ".png"
+		return &stream.Result{Post: &p}
+	}
+
+	for _, mode := range []string{DedupeSkip, DedupeHardlink, DedupeSymlink} {
+		mode := mode
+		t.Run(mode, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := history.Open(filepath.Join(dir, ".redditdl", "history.db"))
+			assert.NoError(t, err)
+			defer store.Close()
+
+			args := defaultArgs(dir, 10)
+			args.Dedupe = mode
+			s := NewSaver(args, 1, 1)
+			s.history = store
+			s.downloadPost(context.TODO(), dir, post("t3_original", "wallpaper"))
+			s.downloadPost(context.TODO(), dir, post("t3_repost", "wallpapers"))
+
+			original := filepath.Join(dir, "wallpaper", "t3_original.png")
+			duplicate := filepath.Join(dir, "wallpapers", "t3_repost.png")
+			assert.True(t, FileExists(original))
+
+			entry, err := store.Get("t3_repost")
+			assert.NoError(t, err)
+			assert.NotNil(t, entry, "deduplicated post should be in the history")
+
+			switch mode {
+			case DedupeSkip:
+				assert.Equal(t, int64(1), s.saved.Load())
+				assert.Equal(t, int64(1), s.skipped.Load())
+				assert.False(t, FileExists(duplicate), "duplicate should be removed")
+				assert.Equal(t, []string{filepath.Join("wallpaper", "t3_original.png")}, entry.Paths)
+			case DedupeHardlink:
+				assert.Equal(t, int64(2), s.saved.Load())
+				a, err := os.Stat(original)
+				assert.NoError(t, err)
+				b, err := os.Stat(duplicate)
+				assert.NoError(t, err)
+				assert.True(t, os.SameFile(a, b), "duplicate should be a hardlink")
+			case DedupeSymlink:
+				assert.Equal(t, int64(2), s.saved.Load())
+				target, err := os.Readlink(duplicate)
+				assert.NoError(t, err)
+				assert.Equal(t, filepath.Join("..", "wallpaper", "t3_original.png"), target)
+			}
+		})
+	}
+}
+
+func TestDeduplicateExistingFiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, err := w.Write([]byte("the same image"))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	// The file was saved before the deduplication was enabled.
+	dir := t.TempDir()
+	existing := filepath.Join("wallpaper", "existing.png")
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "wallpaper"), os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, existing), []byte("the same image"), 0o666))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "wallpaper", "other.png.part"), []byte("the same image"), 0o666))
+
+	store, err := history.Open(filepath.Join(dir, ".redditdl", "history.db"))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	args := defaultArgs(dir, 10)
+	args.Dedupe = DedupeSkip
+	s := NewSaver(args, 1, 1)
+	s.history = store
+	assert.NoError(t, s.indexExistingFiles(dir))
+	indexed, err := store.FilesIndexed()
+	assert.NoError(t, err)
+	assert.True(t, indexed)
+
+	var p api.Post
+	p.Data.Name = "t3_repost"
+	p.Data.Subreddit = "wallpaper"
+	p.Data.PostHint = "image"
+	p.Data.URL = server.URL + "/repost.png"
+	s.downloadPost(context.TODO(), dir, &stream.Result{Post: &p})
+
+	assert.Equal(t, int64(1), s.skipped.Load(), "download should match the file that was in the directory")
+	assert.False(t, FileExists(filepath.Join(dir, "wallpaper", "repost.png")))
+	entry, err := store.Get("t3_repost")
+	assert.NoError(t, err)
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, []string{existing}, entry.Paths)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"unicode/utf8"
 )
 
 // NewFormattedFilename generates a valid filename for the media.
@@ -71,10 +72,11 @@ func (se *FilenameError) Error() string {
 	return se.explanation
 }
 
+// MaxFilenameLength is the maximal length of a path segment. This really only accounts for NTFS.
+const MaxFilenameLength = 255
+
 // formatFilename ensures that the filename is valid for NTFS and has the right extension.
 func formatFilename(filename, extension string) (string, error) {
-	const MaxFilenameLength = 255 // This really only accounts for NTFS.
-
 	if filename == "" {
 		return "", &FilenameError{
 			err:         nil,
@@ -101,6 +103,19 @@ func formatFilename(filename, extension string) (string, error) {
 	return filename + "." + extension, nil
 }
 
+// formatDirname truncates the directory name to the allowed length, like formatFilename does with the filenames.
+// The name is cut at a rune boundary, as some filesystems only accept valid UTF-8 names.
+func formatDirname(dirname string) string {
+	if len(dirname) > MaxFilenameLength {
+		end := MaxFilenameLength
+		for end > 0 && !utf8.RuneStart(dirname[end]) {
+			end--
+		}
+		dirname = strings.TrimSpace(dirname[:end])
+	}
+	return dirname
+}
+
 // removeForbiddenChars removes invalid characters for Linux/Windows filenames.
 func removeForbiddenChars(name string) string {
 	// Most of the characters are forbidden on Windows only.
@@ -6,6 +6,7 @@ require (
 	github.com/alexflint/go-arg v1.4.3
 	github.com/rs/zerolog v1.31.0
 	github.com/stretchr/testify v1.8.2
+	go.etcd.io/bbolt v1.3.8
 )
 
 require (
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/handsomefox/redditdl/history"
+	"github.com/rs/zerolog/log"
+)
+
+// HistoryCommand manages the history of the downloaded posts.
+type HistoryCommand struct {
+	List   *HistoryListCommand  `arg:"subcommand:list" help:"list the downloaded posts"`
+	Prune  *HistoryPruneCommand `arg:"subcommand:prune" help:"remove the entries of old posts, or of posts whose files are gone"`
+	Import *HistoryFileCommand  `arg:"subcommand:import" help:"add the entries from a JSON lines file"`
+	Export *HistoryFileCommand  `arg:"subcommand:export" help:"write all the entries as JSON lines"`
+}
+
+type HistoryListCommand struct{}
+
+type HistoryPruneCommand struct {
+	OlderThan time.Duration `arg:"--older-than" help:"remove the posts downloaded more than this long ago, e.g. 720h"`
+	Missing   bool          `arg:"--missing" help:"remove the posts whose files no longer exist"`
+}
+
+type HistoryFileCommand struct {
+	File string `arg:"positional" help:"path of the file, - for stdin/stdout" default:"-"`
+}
+
+// historyPath returns the path of the history database.
+// By default, it's kept in the output directory, so the history moves along with the downloads.
+func (args *AppArguments) historyPath() string {
+	if args.HistoryPath != "" {
+		return args.HistoryPath
+	}
+	return filepath.Join(args.SaveDirectory, ".redditdl", "history.db")
+}
+
+// runHistory runs the history subcommand, the output (e.g. the listing) is written to w.
+func runHistory(args *AppArguments, w io.Writer) error {
+	store, err := history.Open(args.historyPath())
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	cmd := args.History
+	switch {
+	case cmd.Prune != nil:
+		return pruneHistory(store, cmd.Prune, args.SaveDirectory)
+	case cmd.Import != nil:
+		r := io.Reader(os.Stdin)
+		if cmd.Import.File != "-" {
+			f, err := os.Open(cmd.Import.File)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		n, err := store.Import(r)
+		if err != nil {
+			return err
+		}
+		log.Info().Int("entries", n).Msg("imported the history")
+		return nil
+	case cmd.Export != nil:
+		if cmd.Export.File == "-" {
+			return store.Export(w)
+		}
+		f, err := os.Create(cmd.Export.File)
+		if err != nil {
+			return err
+		}
+		if err := store.Export(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	default:
+		return store.List(func(entry *history.Entry) error {
+			_, err := fmt.Fprintf(w, "%s\t%s\tr/%s\t%s\n",
+				entry.DownloadedAt.Format(time.DateTime), entry.Name, entry.Subreddit, entry.Title)
+			return err
+		})
+	}
+}
+
+// pruneHistory removes the entries matching the prune options.
+// The paths of the entries are relative to the output directory dir.
+func pruneHistory(store *history.Store, cmd *HistoryPruneCommand, dir string) error {
+	if cmd.OlderThan <= 0 && !cmd.Missing {
+		return errors.New("nothing to prune, use --older-than or --missing")
+	}
+	if cmd.Missing && dir == "" {
+		return errors.New("you must provide the output path using -d or --dir to find the missing files")
+	}
+
+	deadline := time.Now().Add(-cmd.OlderThan)
+	n, err := store.Prune(func(entry *history.Entry) bool {
+		if cmd.OlderThan > 0 && entry.DownloadedAt.Before(deadline) {
+			return true
+		}
+		if cmd.Missing && len(entry.Paths) > 0 {
+			for _, p := range entry.Paths {
+				if FileExists(filepath.Join(dir, p)) {
+					return false
+				}
+			}
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	log.Info().Int("entries", n).Msg("pruned the history")
+	return nil
+}
@@ -0,0 +1,388 @@
+// package history contains the persistent history of the downloaded posts,
+// it's used to skip the posts that were downloaded by the previous runs.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// postsBucket maps the post names (e.g. t3_11tug3p) to their entries.
+	postsBucket = []byte("posts")
+	// urlsBucket maps the media urls to the post names.
+	urlsBucket = []byte("urls")
+	// filesBucket maps the content hashes of the saved files to their paths.
+	// The hashes are of the downloaded data, the saved files may differ from it by the embedded metadata.
+	filesBucket = []byte("files")
+	// imagesBucket maps the paths of the saved images to their perceptual hashes.
+	imagesBucket = []byte("images")
+	// metaBucket contains the state of the history itself.
+	metaBucket = []byte("meta")
+)
+
+// filesIndexedKey is set in metaBucket once the files that were in the output directory before are indexed.
+var filesIndexedKey = []byte("files_indexed")
+
+// lockTimeout is how long Open waits for another process to release the database.
+const lockTimeout = 5 * time.Second
+
+var ErrLocked = errors.New("history is used by another process")
+
+// Entry describes a downloaded post.
+type Entry struct {
+	// Name is the fullname of the post, e.g. t3_11tug3p.
+	Name      string `json:"name"`
+	Subreddit string `json:"subreddit,omitempty"`
+	Title     string `json:"title,omitempty"`
+	// URLs are the urls of the downloaded media, there are multiple for galleries.
+	URLs []string `json:"urls,omitempty"`
+	// Paths are the paths of the saved files.
+	Paths        []string  `json:"paths,omitempty"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+}
+
+// Image describes a saved image, the hash is its perceptual hash.
+type Image struct {
+	Hash   uint64 `json:"hash"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Store is the history kept in an embedded database file.
+// It is safe for concurrent use.
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens the history at the path, creating it (and its directory) if needed.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o666, &bolt.Options{Timeout: lockTimeout})
+	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, fmt.Errorf("%w: %s", ErrLocked, path)
+		}
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{postsBucket, urlsBucket, filesBucket, imagesBucket, metaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Seen reports whether the post with the name, or the media with the url, was already downloaded.
+// Empty values are ignored.
+func (s *Store) Seen(name, url string) (bool, error) {
+	var seen bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		seen = name != "" && tx.Bucket(postsBucket).Get([]byte(name)) != nil ||
+			url != "" && tx.Bucket(urlsBucket).Get([]byte(url)) != nil
+		return nil
+	})
+	return seen, err
+}
+
+// Get returns the entry of the post, or nil if it wasn't downloaded.
+func (s *Store) Get(name string) (*Entry, error) {
+	var entry *Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(postsBucket).Get([]byte(name))
+		if b == nil {
+			return nil
+		}
+		entry = new(Entry)
+		return json.Unmarshal(b, entry)
+	})
+	return entry, err
+}
+
+// Add adds the entries to the history, replacing the existing entries of the same posts.
+func (s *Store) Add(entries ...Entry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for i := range entries {
+			if err := put(tx, &entries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func put(tx *bolt.Tx, entry *Entry) error {
+	if entry.Name == "" {
+		return fmt.Errorf("history entry has no post name")
+	}
+	if err := remove(tx, []byte(entry.Name)); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(postsBucket).Put([]byte(entry.Name), b); err != nil {
+		return err
+	}
+	for _, url := range entry.URLs {
+		if url == "" {
+			continue
+		}
+		if err := tx.Bucket(urlsBucket).Put([]byte(url), []byte(entry.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove deletes the entry of the post along with its urls.
+func remove(tx *bolt.Tx, name []byte) error {
+	posts := tx.Bucket(postsBucket)
+	b := posts.Get(name)
+	if b == nil {
+		return nil
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(b, &entry); err == nil {
+		urls := tx.Bucket(urlsBucket)
+		for _, url := range entry.URLs {
+			// The url may belong to another post (e.g. a crosspost) by now.
+			if string(urls.Get([]byte(url))) == entry.Name {
+				if err := urls.Delete([]byte(url)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return posts.Delete(name)
+}
+
+// List calls fn for each entry, in the order of the post names.
+func (s *Store) List(fn func(*Entry) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(postsBucket).ForEach(func(_, b []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(b, &entry); err != nil {
+				return err
+			}
+			return fn(&entry)
+		})
+	})
+}
+
+// Prune removes the entries for which shouldRemove returns true, and returns the amount of removed entries.
+func (s *Store) Prune(shouldRemove func(*Entry) bool) (int, error) {
+	var names [][]byte
+	err := s.List(func(entry *Entry) error {
+		if shouldRemove(entry) {
+			names = append(names, []byte(entry.Name))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range names {
+			if err := remove(tx, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(names), nil
+}
+
+// File returns the path of the saved file with the content hash, or an empty string if there is none.
+func (s *Store) File(hash string) (string, error) {
+	var path string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		path = string(tx.Bucket(filesBucket).Get([]byte(hash)))
+		return nil
+	})
+	return path, err
+}
+
+// AddFile records the path of the saved file with the content hash, replacing the previous one.
+func (s *Store) AddFile(hash, path string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(filesBucket).Put([]byte(hash), []byte(path))
+	})
+}
+
+// FilesIndexed reports whether IndexFiles was called before.
+func (s *Store) FilesIndexed() (bool, error) {
+	var indexed bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		indexed = tx.Bucket(metaBucket).Get(filesIndexedKey) != nil
+		return nil
+	})
+	return indexed, err
+}
+
+// IndexFiles records the paths of the files with the content hashes (keyed by the hashes),
+// keeping the paths recorded before, and marks the existing files as indexed.
+func (s *Store) IndexFiles(files map[string]string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(filesBucket)
+		for hash, path := range files {
+			if b.Get([]byte(hash)) != nil {
+				continue
+			}
+			if err := b.Put([]byte(hash), []byte(path)); err != nil {
+				return err
+			}
+		}
+		return tx.Bucket(metaBucket).Put(filesIndexedKey, []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+}
+
+// AddImage records the perceptual hash of the saved image at the path.
+func (s *Store) AddImage(path string, image Image) error {
+	b, err := json.Marshal(image)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).Put([]byte(path), b)
+	})
+}
+
+// RemoveImage removes the image at the path, e.g. when a better copy of it was saved.
+func (s *Store) RemoveImage(path string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).Delete([]byte(path))
+	})
+}
+
+// ReplacePath replaces the path of a removed file with the path of its replacement,
+// in the entries of the posts and in the hash index of the files.
+func (s *Store) ReplacePath(old, replacement string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		posts := tx.Bucket(postsBucket)
+		var entries []Entry
+		err := posts.ForEach(func(_, b []byte) error {
+			var entry Entry
+			if err := json.Unmarshal(b, &entry); err != nil {
+				return err
+			}
+			replaced := false
+			for i := range entry.Paths {
+				if entry.Paths[i] == old {
+					entry.Paths[i], replaced = replacement, true
+				}
+			}
+			if replaced {
+				entries = append(entries, entry)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// The buckets can't be modified while they are iterated.
+		for i := range entries {
+			b, err := json.Marshal(&entries[i])
+			if err != nil {
+				return err
+			}
+			if err := posts.Put([]byte(entries[i].Name), b); err != nil {
+				return err
+			}
+		}
+
+		files := tx.Bucket(filesBucket)
+		var hashes [][]byte
+		err = files.ForEach(func(hash, path []byte) error {
+			if string(path) == old {
+				hashes = append(hashes, append([]byte(nil), hash...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			if err := files.Put(hash, []byte(replacement)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Images calls fn for each saved image, in the order of the paths.
+func (s *Store) Images(fn func(path string, image Image) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(imagesBucket).ForEach(func(k, b []byte) error {
+			var image Image
+			if err := json.Unmarshal(b, &image); err != nil {
+				return err
+			}
+			return fn(string(k), image)
+		})
+	})
+}
+
+// Export writes all the entries to w as JSON lines.
+func (s *Store) Export(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	if err := s.List(func(entry *Entry) error { return enc.Encode(entry) }); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Import adds the entries read from r as JSON lines (the Export format), and returns the amount of them.
+func (s *Store) Import(r io.Reader) (int, error) {
+	var entries []Entry
+	dec := json.NewDecoder(r)
+	for {
+		var entry Entry
+		if err := dec.Decode(&entry); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return 0, fmt.Errorf("failed to decode history entry %d: %w", len(entries)+1, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := s.Add(entries...); err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
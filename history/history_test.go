@@ -0,0 +1,139 @@
+package history
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), ".redditdl", "history.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+	s := openStore(t)
+
+	seen, err := s.Seen("t3_11tug3p", "https://i.redd.it/05sk8tzriboa1.png")
+	assert.NoError(t, err)
+	assert.False(t, seen)
+
+	assert.NoError(t, s.Add(Entry{
+		Name:         "t3_11tug3p",
+		Subreddit:    "wallpaper",
+		URLs:         []string{"https://i.redd.it/05sk8tzriboa1.png"},
+		DownloadedAt: time.Now(),
+	}))
+
+	seen, err = s.Seen("t3_11tug3p", "")
+	assert.NoError(t, err)
+	assert.True(t, seen, "post should be found by its name")
+	seen, err = s.Seen("t3_crosspost", "https://i.redd.it/05sk8tzriboa1.png")
+	assert.NoError(t, err)
+	assert.True(t, seen, "post should be found by its media url")
+	seen, err = s.Seen("", "")
+	assert.NoError(t, err)
+	assert.False(t, seen)
+
+	entry, err := s.Get("t3_11tug3p")
+	assert.NoError(t, err)
+	assert.Equal(t, "wallpaper", entry.Subreddit)
+
+	path, err := s.File("abc")
+	assert.NoError(t, err)
+	assert.Empty(t, path)
+	assert.NoError(t, s.AddFile("abc", "wallpaper/image.png"))
+	path, err = s.File("abc")
+	assert.NoError(t, err)
+	assert.Equal(t, "wallpaper/image.png", path)
+
+	assert.NoError(t, s.AddImage("wallpaper/a.png", Image{Hash: 1, Width: 10, Height: 20}))
+	assert.NoError(t, s.AddImage("wallpaper/b.png", Image{Hash: 2}))
+	assert.NoError(t, s.RemoveImage("wallpaper/a.png"))
+	var images []string
+	assert.NoError(t, s.Images(func(path string, image Image) error {
+		images = append(images, path)
+		return nil
+	}))
+	assert.Equal(t, []string{"wallpaper/b.png"}, images)
+
+	_, err = Open(filepath.Join(t.TempDir(), "history.db"))
+	assert.NoError(t, err, "another history should not be locked")
+}
+
+func TestPrune(t *testing.T) {
+	t.Parallel()
+	s := openStore(t)
+	now := time.Now()
+	assert.NoError(t, s.Add(
+		Entry{Name: "t3_old", URLs: []string{"https://i.redd.it/old.png"}, DownloadedAt: now.Add(-48 * time.Hour)},
+		Entry{Name: "t3_new", URLs: []string{"https://i.redd.it/new.png"}, DownloadedAt: now},
+	))
+
+	n, err := s.Prune(func(e *Entry) bool { return e.DownloadedAt.Before(now.Add(-24 * time.Hour)) })
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	seen, err := s.Seen("", "https://i.redd.it/old.png")
+	assert.NoError(t, err)
+	assert.False(t, seen, "urls of the pruned entries should be removed")
+	seen, err = s.Seen("t3_new", "")
+	assert.NoError(t, err)
+	assert.True(t, seen)
+}
+
+func TestReplacePath(t *testing.T) {
+	t.Parallel()
+	s := openStore(t)
+	assert.NoError(t, s.Add(
+		Entry{Name: "t3_a", Paths: []string{"a/small.png", "a/other.png"}},
+		Entry{Name: "t3_b", Paths: []string{"b/other.png"}},
+	))
+	assert.NoError(t, s.AddFile("hash", "a/small.png"))
+
+	assert.NoError(t, s.ReplacePath("a/small.png", "b/large.png"))
+	entry, err := s.Get("t3_a")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b/large.png", "a/other.png"}, entry.Paths)
+	entry, err = s.Get("t3_b")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b/other.png"}, entry.Paths)
+	path, err := s.File("hash")
+	assert.NoError(t, err)
+	assert.Equal(t, "b/large.png", path)
+}
+
+func TestExportImport(t *testing.T) {
+	t.Parallel()
+	s := openStore(t)
+	entries := []Entry{
+		{Name: "t3_a", Title: "first", URLs: []string{"https://i.redd.it/a.png"}, Paths: []string{"wallpaper/a.png"}},
+		{Name: "t3_b", Title: "second", URLs: []string{"https://i.redd.it/b.png"}},
+	}
+	assert.NoError(t, s.Add(entries...))
+
+	var buf bytes.Buffer
+	assert.NoError(t, s.Export(&buf))
+
+	imported := openStore(t)
+	n, err := imported.Import(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	var names []string
+	assert.NoError(t, imported.List(func(e *Entry) error {
+		names = append(names, e.Name)
+		return nil
+	}))
+	assert.Equal(t, []string{"t3_a", "t3_b"}, names)
+
+	_, err = imported.Import(bytes.NewBufferString(`{"name": "t3_c"} not json`))
+	assert.Error(t, err)
+}
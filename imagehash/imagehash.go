@@ -0,0 +1,169 @@
+// package imagehash contains the perceptual hashes of the images, they're used to find
+// the reposts of the same image, even if it was resized or recompressed.
+package imagehash
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif" // Register the decoders of the formats reddit serves.
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+	"sort"
+)
+
+const (
+	width  = 9
+	height = 8
+	// maxSamples is the maximal amount of pixels sampled in each direction of a cell,
+	// so that large images are hashed fast.
+	maxSamples = 16
+)
+
+// Hash is the perceptual hash of an image, along with the image resolution.
+type Hash struct {
+	Value  uint64
+	Width  int
+	Height int
+}
+
+// Pixels returns the amount of pixels in the image.
+func (h Hash) Pixels() int {
+	return h.Width * h.Height
+}
+
+// DHash returns the difference hash of the image: the image is shrunk to 9x8 grayscale cells,
+// and each bit of the hash tells whether a cell is brighter than its right neighbour.
+func DHash(img image.Image) uint64 {
+	var cells [height][width]float64
+	bounds := img.Bounds()
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			cells[y][x] = brightness(img, cell(bounds, x, y))
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			hash <<= 1
+			if cells[y][x] > cells[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// cell returns the area of the image covered by the cell of the shrunk image.
+func cell(bounds image.Rectangle, x, y int) image.Rectangle {
+	dx, dy := bounds.Dx(), bounds.Dy()
+	r := image.Rect(
+		bounds.Min.X+x*dx/width, bounds.Min.Y+y*dy/height,
+		bounds.Min.X+(x+1)*dx/width, bounds.Min.Y+(y+1)*dy/height,
+	)
+	// Images smaller than the hash still need a pixel per cell.
+	if r.Dx() == 0 {
+		r.Max.X = r.Min.X + 1
+	}
+	if r.Dy() == 0 {
+		r.Max.Y = r.Min.Y + 1
+	}
+	return r.Intersect(bounds)
+}
+
+// brightness returns the average luminance of the area.
+func brightness(img image.Image, r image.Rectangle) float64 {
+	if r.Empty() {
+		return 0
+	}
+	stepX := max(1, r.Dx()/maxSamples)
+	stepY := max(1, r.Dy()/maxSamples)
+
+	var sum float64
+	var n int
+	for y := r.Min.Y; y < r.Max.Y; y += stepY {
+		for x := r.Min.X; x < r.Max.X; x += stepX {
+			cr, cg, cb, _ := img.At(x, y).RGBA()
+			sum += 0.299*float64(cr) + 0.587*float64(cg) + 0.114*float64(cb)
+			n++
+		}
+	}
+	return sum / float64(n)
+}
+
+// Distance returns the Hamming distance of the hashes, the amount of different bits.
+// Hashes of the same image are usually within 5 bits.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// File decodes the image at the path and returns its hash.
+func File(path string) (Hash, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Hash{}, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return Hash{}, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	bounds := img.Bounds()
+	return Hash{Value: DHash(img), Width: bounds.Dx(), Height: bounds.Dy()}, nil
+}
+
+// Clusters groups the images, whose hashes are within the distance of each other (transitively).
+// Only the groups of multiple images are returned, they're sorted from the highest resolution to the lowest.
+func Clusters(hashes map[string]Hash, distance int) [][]string {
+	paths := make([]string, 0, len(hashes))
+	for path := range hashes {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	// Union-find over the indices of the paths.
+	parent := make([]int, len(paths))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range paths {
+		for j := i + 1; j < len(paths); j++ {
+			if Distance(hashes[paths[i]].Value, hashes[paths[j]].Value) <= distance {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	groups := make(map[int][]string)
+	var roots []int
+	for i, path := range paths {
+		root := find(i)
+		if _, ok := groups[root]; !ok {
+			roots = append(roots, root)
+		}
+		groups[root] = append(groups[root], path)
+	}
+
+	var clusters [][]string
+	for _, root := range roots {
+		cluster := groups[root]
+		if len(cluster) < 2 {
+			continue
+		}
+		sort.SliceStable(cluster, func(i, j int) bool {
+			return hashes[cluster[i]].Pixels() > hashes[cluster[j]].Pixels()
+		})
+		clusters = append(clusters, cluster)
+	}
+	return clusters
+}
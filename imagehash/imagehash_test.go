@@ -0,0 +1,78 @@
+package imagehash
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testImage draws a diagonal gradient, flipped horizontally if mirrored.
+func testImage(w, h int, mirrored bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx := x
+			if mirrored {
+				fx = w - 1 - x
+			}
+			v := uint8((fx*255/w + y*255/h) / 2)
+			// Stripes make the rows differ from each other.
+			if (y*8/h)%2 == 0 {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	t.Parallel()
+	big := DHash(testImage(1200, 900, false))
+	small := DHash(testImage(300, 225, false))
+	mirrored := DHash(testImage(1200, 900, true))
+
+	assert.LessOrEqual(t, Distance(big, small), 5, "resized image should have a similar hash")
+	assert.Greater(t, Distance(big, mirrored), 20, "different image should have a different hash")
+	assert.Equal(t, 0, Distance(big, big))
+
+	assert.NotPanics(t, func() { DHash(testImage(3, 2, false)) }, "tiny images should be hashed")
+}
+
+func TestFileAndClusters(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	write := func(name string, img image.Image, quality int) string {
+		path := filepath.Join(dir, name)
+		f, err := os.Create(path)
+		assert.NoError(t, err)
+		defer f.Close()
+		assert.NoError(t, jpeg.Encode(f, img, &jpeg.Options{Quality: quality}))
+		return path
+	}
+
+	hashes := make(map[string]Hash)
+	for name, img := range map[string]image.Image{
+		"original.jpg": testImage(800, 600, false),
+		"repost.jpg":   testImage(400, 300, false),
+		"other.jpg":    testImage(800, 600, true),
+	} {
+		hash, err := File(write(name, img, 60))
+		assert.NoError(t, err)
+		hashes[name] = hash
+	}
+	assert.Equal(t, 800, hashes["original.jpg"].Width)
+	assert.Equal(t, 300, hashes["repost.jpg"].Height)
+
+	clusters := Clusters(hashes, 5)
+	assert.Equal(t, [][]string{{"original.jpg", "repost.jpg"}}, clusters,
+		"the cluster should start with the highest resolution image")
+
+	_, err := File(filepath.Join(dir, "missing.jpg"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,90 @@
+// package imagemeta embeds the post metadata into the saved images, so it stays with the file when it's copied around.
+// The metadata segments (JPEG) and chunks (PNG) are rewritten, the image data is copied as is.
+package imagemeta
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var ErrUnsupportedFormat = errors.New("image format does not support metadata embedding")
+
+var (
+	jpegMagic = []byte{0xFF, 0xD8, 0xFF}
+	pngMagic  = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// Fields is the metadata embedded into the images, empty fields are omitted.
+type Fields struct {
+	Title     string
+	Author    string
+	Subreddit string
+	// Permalink is the url of the post page.
+	Permalink string
+	Created   time.Time
+}
+
+// Embed embeds the fields into the JPEG or PNG image at the path, replacing the metadata embedded before.
+// The file is replaced atomically. Other formats are reported with ErrUnsupportedFormat.
+func Embed(path string, fields Fields) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	r := bufio.NewReader(src)
+	magic, err := r.Peek(len(pngMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	var embed func(io.Writer, io.Reader, Fields) error
+	switch {
+	case bytes.HasPrefix(magic, jpegMagic):
+		embed = EmbedJPEG
+	case bytes.HasPrefix(magic, pngMagic):
+		embed = EmbedPNG
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedFormat, path)
+	}
+
+	stat, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails once it's renamed.
+
+	w := bufio.NewWriter(tmp)
+	if err := embed(w, r, fields); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(stat.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	// The file replaces the finished download, it has to be on the disk before the rename.
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	src.Close() // Windows can't replace open files.
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,101 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testFields = Fields{
+	Title:     "Staring into the woods <3 — 3840x2160",
+	Author:    "The_Romero",
+	Subreddit: "wallpaper",
+	Permalink: "https://www.reddit.com/r/wallpaper/comments/11tug3p/staring_into_the_woods/",
+	Created:   time.Unix(1679043600, 0),
+}
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for x := 0; x < 16; x++ {
+		img.Set(x, x%8, color.RGBA{uint8(x * 16), 0, 0, 255})
+	}
+	return img
+}
+
+// embed embeds the fields into the file and returns the new content.
+func embed(t *testing.T, b []byte, fields Fields) []byte {
+	path := filepath.Join(t.TempDir(), "image")
+	assert.NoError(t, os.WriteFile(path, b, 0o600))
+	assert.NoError(t, Embed(path, fields))
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	return b
+}
+
+func TestEmbedJPEG(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, testImage(), nil))
+	original := buf.Bytes()
+
+	b := embed(t, original, testFields)
+	_, err := jpeg.Decode(bytes.NewReader(b))
+	assert.NoError(t, err, "image should still be decodable")
+	assert.Equal(t, 1, bytes.Count(b, xmpHeader))
+	assert.Equal(t, 1, bytes.Count(b, exifHeader))
+	assert.True(t, bytes.Contains(b, []byte("<rdf:li>The_Romero</rdf:li>")))
+	assert.True(t, bytes.Contains(b, []byte("Staring into the woods &lt;3")), "XMP values should be escaped")
+	// The image data is not re-encoded.
+	sos := bytes.Index(original, []byte{0xFF, markerSOS})
+	assert.True(t, bytes.HasSuffix(b, original[sos:]))
+
+	// EXIF: ImageDescription is the first entry of IFD0.
+	exif := b[bytes.Index(b, exifHeader)+len(exifHeader):]
+	assert.Equal(t, uint16(2), binary.BigEndian.Uint16(exif[8:]))
+	assert.Equal(t, uint16(0x010E), binary.BigEndian.Uint16(exif[10:]))
+	offset := binary.BigEndian.Uint32(exif[18:])
+	assert.True(t, bytes.HasPrefix(exif[offset:], []byte(testFields.Title+"\x00")))
+
+	again := embed(t, b, Fields{Title: "New title"})
+	assert.Equal(t, 1, bytes.Count(again, xmpHeader), "XMP should be replaced")
+	assert.Equal(t, 1, bytes.Count(again, exifHeader), "EXIF should be kept")
+	assert.True(t, bytes.Contains(again, []byte("New title")))
+}
+
+func TestEmbedPNG(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, testImage()))
+
+	b := embed(t, buf.Bytes(), testFields)
+	_, err := png.Decode(bytes.NewReader(b))
+	assert.NoError(t, err, "image should still be decodable, with valid checksums")
+	assert.True(t, bytes.Contains(b, []byte("tEXtAuthor\x00The_Romero")))
+	assert.True(t, bytes.Contains(b, []byte("iTXtTitle\x00\x00\x00\x00\x00Staring")), "UTF-8 values should be in iTXt")
+	assert.True(t, bytes.Contains(b, []byte("iTXtXML:com.adobe.xmp\x00")))
+
+	again := embed(t, b, testFields)
+	assert.Equal(t, len(b), len(again), "text chunks should be replaced")
+	_, err = png.Decode(bytes.NewReader(again))
+	assert.NoError(t, err)
+}
+
+func TestEmbedUnsupported(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "image.gif")
+	assert.NoError(t, os.WriteFile(path, []byte("GIF89a"), 0o600))
+	assert.ErrorIs(t, Embed(path, testFields), ErrUnsupportedFormat)
+
+	var out bytes.Buffer
+	assert.Error(t, EmbedJPEG(&out, bytes.NewReader([]byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00}), testFields),
+		"truncated image should not be accepted")
+}
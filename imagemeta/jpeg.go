@@ -0,0 +1,181 @@
+package imagemeta
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	markerSOI  = 0xD8
+	markerSOS  = 0xDA
+	markerAPP0 = 0xE0
+	markerAPP1 = 0xE1
+	// maxSegment is the maximal payload of a JPEG segment, the length field includes its own 2 bytes.
+	maxSegment = 0xFFFF - 2
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte(xmpNamespace + "\x00")
+)
+
+// EmbedJPEG copies the JPEG image from r to w with the fields embedded as XMP, and as EXIF
+// (the image description and the artist) unless the image already has EXIF data, which is kept.
+// XMP embedded before is replaced.
+func EmbedJPEG(w io.Writer, r io.Reader, fields Fields) error {
+	br := bufio.NewReader(r)
+	var soi [2]byte
+	if _, err := io.ReadFull(br, soi[:]); err != nil {
+		return fmt.Errorf("failed to read JPEG header: %w", err)
+	}
+	if soi[0] != 0xFF || soi[1] != markerSOI {
+		return fmt.Errorf("%w: not a JPEG image", ErrUnsupportedFormat)
+	}
+
+	// The metadata segments are read up to the image data, so the new ones can be placed in front of them.
+	var segments [][]byte
+	hasEXIF := false
+	for {
+		marker, payload, err := readSegment(br)
+		if err != nil {
+			return err
+		}
+		if marker == markerSOS {
+			segments = append(segments, segment(marker, payload))
+			break
+		}
+		if marker == markerAPP1 && bytes.HasPrefix(payload, xmpHeader) {
+			continue
+		}
+		if marker == markerAPP1 && bytes.HasPrefix(payload, exifHeader) {
+			hasEXIF = true
+		}
+		segments = append(segments, segment(marker, payload))
+	}
+
+	if _, err := w.Write(soi[:]); err != nil {
+		return err
+	}
+	// JFIF requires its segment to be the first one.
+	if len(segments) > 0 && segments[0][1] == markerAPP0 {
+		if _, err := w.Write(segments[0]); err != nil {
+			return err
+		}
+		segments = segments[1:]
+	}
+	if !hasEXIF {
+		if exif := exifPayload(fields); exif != nil {
+			if _, err := w.Write(segment(markerAPP1, exif)); err != nil {
+				return err
+			}
+		}
+	}
+	xmp := append(append([]byte{}, xmpHeader...), xmpPacket(fields)...)
+	if len(xmp) > maxSegment {
+		return fmt.Errorf("XMP metadata is too large: %d bytes", len(xmp))
+	}
+	if _, err := w.Write(segment(markerAPP1, xmp)); err != nil {
+		return err
+	}
+	for _, s := range segments {
+		if _, err := w.Write(s); err != nil {
+			return err
+		}
+	}
+
+	// The rest is the entropy-coded image data, it's copied as is.
+	_, err := io.Copy(w, br)
+	return err
+}
+
+// readSegment reads the marker and the payload of the next segment.
+func readSegment(r *bufio.Reader) (byte, []byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:2]); err != nil {
+		return 0, nil, fmt.Errorf("failed to read JPEG segment: %w", err)
+	}
+	if header[0] != 0xFF {
+		return 0, nil, fmt.Errorf("%w: invalid JPEG marker", ErrUnsupportedFormat)
+	}
+	marker := header[1]
+	// Markers may be padded with fill bytes.
+	for marker == 0xFF {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to read JPEG segment: %w", err)
+		}
+		marker = b
+	}
+
+	if _, err := io.ReadFull(r, header[2:]); err != nil {
+		return 0, nil, fmt.Errorf("failed to read JPEG segment: %w", err)
+	}
+	length := int(binary.BigEndian.Uint16(header[2:]))
+	if length < 2 {
+		return 0, nil, fmt.Errorf("%w: invalid JPEG segment length", ErrUnsupportedFormat)
+	}
+	payload := make([]byte, length-2)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, fmt.Errorf("failed to read JPEG segment: %w", err)
+	}
+	return marker, payload, nil
+}
+
+// segment serializes the segment with the marker and the payload.
+func segment(marker byte, payload []byte) []byte {
+	b := []byte{0xFF, marker}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)+2))
+	return append(b, payload...)
+}
+
+// exifPayload returns the EXIF data with the title as the image description and the author as the artist,
+// or nil if both are empty.
+func exifPayload(fields Fields) []byte {
+	type entry struct {
+		tag   uint16
+		value string
+	}
+	var entries []entry
+	// The tags have to be sorted.
+	if fields.Title != "" {
+		entries = append(entries, entry{0x010E, fields.Title}) // ImageDescription
+	}
+	if fields.Author != "" {
+		entries = append(entries, entry{0x013B, fields.Author}) // Artist
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	const typeASCII = 2
+	// TIFF header, IFD0: the entry count, 12 bytes per entry, the offset of the next IFD.
+	ifd := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	ifd = binary.BigEndian.AppendUint16(ifd, uint16(len(entries)))
+	dataOffset := len(ifd) + len(entries)*12 + 4
+	var data []byte
+	for _, e := range entries {
+		value := append([]byte(e.value), 0)
+		ifd = binary.BigEndian.AppendUint16(ifd, e.tag)
+		ifd = binary.BigEndian.AppendUint16(ifd, typeASCII)
+		ifd = binary.BigEndian.AppendUint32(ifd, uint32(len(value)))
+		if len(value) <= 4 {
+			ifd = append(ifd, append(value, make([]byte, 4-len(value))...)...)
+			continue
+		}
+		ifd = binary.BigEndian.AppendUint32(ifd, uint32(dataOffset+len(data)))
+		data = append(data, value...)
+		if len(data)%2 == 1 {
+			data = append(data, 0) // The values start on word boundaries.
+		}
+	}
+	ifd = binary.BigEndian.AppendUint32(ifd, 0)
+
+	payload := append(append(append([]byte{}, exifHeader...), ifd...), data...)
+	if len(payload) > maxSegment {
+		return nil
+	}
+	return payload
+}
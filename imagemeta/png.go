@@ -0,0 +1,138 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxChunk is the maximal length of a PNG chunk, the longer chunks are corrupted.
+const maxChunk = 1 << 31
+
+// pngKeywords are the keywords of the text chunks written by EmbedPNG.
+var pngKeywords = map[string]bool{
+	"Title": true, "Author": true, "Source": true, "Subreddit": true, "Creation Time": true, "XML:com.adobe.xmp": true,
+}
+
+// EmbedPNG copies the PNG image from r to w with the fields embedded as text chunks, along with an XMP chunk.
+// The text chunks written before are replaced.
+func EmbedPNG(w io.Writer, r io.Reader, fields Fields) error {
+	var signature [8]byte
+	if _, err := io.ReadFull(r, signature[:]); err != nil {
+		return fmt.Errorf("failed to read PNG header: %w", err)
+	}
+	if !bytes.Equal(signature[:], pngMagic) {
+		return fmt.Errorf("%w: not a PNG image", ErrUnsupportedFormat)
+	}
+	if _, err := w.Write(signature[:]); err != nil {
+		return err
+	}
+
+	for written := false; ; {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return fmt.Errorf("failed to read PNG chunk: %w", err)
+		}
+		length, typ := binary.BigEndian.Uint32(header[:4]), string(header[4:])
+		if length >= maxChunk {
+			return fmt.Errorf("%w: invalid PNG chunk length", ErrUnsupportedFormat)
+		}
+
+		if typ == "tEXt" || typ == "iTXt" || typ == "zTXt" {
+			// The data is followed by the CRC.
+			data := make([]byte, length+4)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return fmt.Errorf("failed to read PNG chunk: %w", err)
+			}
+			if isEmbeddedText(data[:length]) {
+				continue
+			}
+			if _, err := w.Write(append(header[:], data...)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Other chunks (e.g. the image data) are copied as is.
+		if _, err := w.Write(header[:]); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(w, r, int64(length)+4); err != nil {
+			return fmt.Errorf("failed to copy PNG chunk: %w", err)
+		}
+		if typ == "IEND" {
+			return nil
+		}
+		// The text goes right after the header, so it's found without reading the image data.
+		if typ == "IHDR" && !written {
+			written = true
+			if err := writeTextChunks(w, fields); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writeTextChunks(w io.Writer, fields Fields) error {
+	texts := []struct{ keyword, value string }{
+		{"Title", fields.Title},
+		{"Author", fields.Author},
+		{"Subreddit", fields.Subreddit},
+		{"Source", fields.Permalink},
+	}
+	if !fields.Created.IsZero() {
+		texts = append(texts, struct{ keyword, value string }{"Creation Time", fields.Created.UTC().Format(time.RFC1123Z)})
+	}
+	for _, text := range texts {
+		if text.value == "" {
+			continue
+		}
+		typ, data := textChunk(text.keyword, text.value)
+		if err := writeChunk(w, typ, data); err != nil {
+			return err
+		}
+	}
+	_, xmp := internationalTextChunk("XML:com.adobe.xmp", string(xmpPacket(fields)))
+	return writeChunk(w, "iTXt", xmp)
+}
+
+// textChunk returns a tEXt chunk for ASCII values, and an iTXt (UTF-8) chunk for the others,
+// as tEXt is limited to Latin-1.
+func textChunk(keyword, value string) (string, []byte) {
+	value = strings.ReplaceAll(value, "\x00", "")
+	for i := 0; i < len(value); i++ {
+		if value[i] >= utf8.RuneSelf {
+			return internationalTextChunk(keyword, value)
+		}
+	}
+	return "tEXt", append(append([]byte(keyword), 0), value...)
+}
+
+// internationalTextChunk returns an uncompressed iTXt chunk without the language tags.
+func internationalTextChunk(keyword, value string) (string, []byte) {
+	data := append([]byte(keyword), 0)
+	// Compression flag, compression method, empty language tag, empty translated keyword.
+	data = append(data, 0, 0, 0, 0)
+	return "iTXt", append(data, value...)
+}
+
+// isEmbeddedText reports whether the text chunk has one of the keywords written by EmbedPNG.
+func isEmbeddedText(data []byte) bool {
+	keyword, _, ok := bytes.Cut(data, []byte{0})
+	return ok && pngKeywords[string(keyword)]
+}
+
+func writeChunk(w io.Writer, typ string, data []byte) error {
+	b := make([]byte, 0, len(data)+12)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	b = append(b, typ...)
+	b = append(b, data...)
+	b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[4:]))
+	_, err := w.Write(b)
+	return err
+}
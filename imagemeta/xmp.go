@@ -0,0 +1,38 @@
+package imagemeta
+
+import (
+	"bytes"
+	"encoding/xml"
+	"time"
+)
+
+// xmpNamespace identifies the XMP packets in JPEG segments, and is the keyword of XMP chunks in PNG.
+const xmpNamespace = "http://ns.adobe.com/xap/1.0/"
+
+// xmpPacket returns the XMP packet describing the fields with the Dublin Core properties:
+// the title, the creator (author), the subject (subreddit) and the source (permalink).
+func xmpPacket(fields Fields) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("<?xpacket begin=\"\xEF\xBB\xBF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	buf.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">` + "\n")
+	buf.WriteString(` <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` + "\n")
+	buf.WriteString(`  <rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="` + xmpNamespace + `">` + "\n")
+	property := func(name, open, value, close string) {
+		if value == "" {
+			return
+		}
+		buf.WriteString("   <" + name + ">" + open)
+		xml.EscapeText(&buf, []byte(value)) //nolint:errcheck // Writes to a buffer don't fail.
+		buf.WriteString(close + "</" + name + ">\n")
+	}
+	property("dc:title", `<rdf:Alt><rdf:li xml:lang="x-default">`, fields.Title, `</rdf:li></rdf:Alt>`)
+	property("dc:creator", `<rdf:Seq><rdf:li>`, fields.Author, `</rdf:li></rdf:Seq>`)
+	property("dc:subject", `<rdf:Bag><rdf:li>`, fields.Subreddit, `</rdf:li></rdf:Bag>`)
+	property("dc:source", "", fields.Permalink, "")
+	if !fields.Created.IsZero() {
+		property("xmp:CreateDate", "", fields.Created.UTC().Format(time.RFC3339), "")
+	}
+	buf.WriteString("  </rdf:Description>\n </rdf:RDF>\n</x:xmpmeta>\n")
+	buf.WriteString(`<?xpacket end="w"?>`)
+	return buf.Bytes()
+}
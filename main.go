@@ -2,60 +2,220 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"os/exec"
+	"os/signal"
 	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/alexflint/go-arg"
+	"github.com/handsomefox/redditdl/api"
+	"github.com/handsomefox/redditdl/stream"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
+// exitInterrupted is the exit code of the app stopped by a signal, as the shells report it for SIGINT.
+const exitInterrupted = 130
+
 type AppArguments struct {
 	SubredditContentType string `arg:"-t,--type" help:"values: image,video,both" default:"image"`
 	SubredditSort        string `arg:"-s,--sort" help:"values: controversial/best/hot/new/random/rising/top" default:"top"`
 	SubredditTimeframe   string `arg:"-f,--timeframe" help:"values: hour/day/week/month/year/all" default:"all"`
-	SubredditList        string `arg:"-r,--subreddits" help:"a comma-separated list of subreddits to download from"`
+	SubredditList        string `arg:"-r,--subreddits" help:"a comma-separated list of subreddits to download from, a+b+c and /user/{user}/m/{multireddit} are fetched as one"`
+	Users                string `arg:"-u,--users" help:"a comma-separated list of users to download the submissions of"`
+	Search               string `arg:"--search" help:"a query to download the search results of"`
+	SearchIn             string `arg:"--search-in" help:"a subreddit to restrict the search to"`
+	SearchSort           string `arg:"--search-sort" help:"values: relevance/hot/top/new/comments" default:"relevance"`
+	SearchType           string `arg:"--search-type" help:"values: link/sr/user, a comma-separated list of the types of the search results (only the links have media)" default:"link"`
+	Saved                bool   `arg:"--saved" help:"download the saved posts of the authenticated user"`
+	Upvoted              bool   `arg:"--upvoted" help:"download the upvoted posts of the authenticated user"`
+	ItemKind             string `arg:"--kind" help:"values: links/comments/all, kind of the saved and upvoted items (comments download the media of their post)" default:"links"`
+	Unsave               bool   `arg:"--unsave" help:"unsave the saved posts after they were downloaded"`
 	SaveDirectory        string `arg:"-d,--dir" help:"output path"`
+	OutputTemplate       string `arg:"--output-template" help:"path of the saved media in the output directory, e.g. {subreddit}/{year}/{month}/{id}_{title}.{ext}; placeholders: id/name/title/author/subreddit/year/month/day/date/score/width/height/orientation/flair/index/ext"`
+	HistoryPath          string `arg:"--history" help:"path of the download history (default: {dir}/.redditdl/history.db)"`
+	NoHistory            bool   `arg:"--no-history" help:"download the posts even if they were downloaded before, without recording them"`
 
 	MediaOrientation   string `arg:"-o, --orientation" help:"values: landspace/portrait/rect/all" default:"all"`
 	MediaCount         int64  `arg:"-c, --count" help:"amount of media to download"`
+	CountPerSource     int64  `arg:"--count-per-source" help:"amount of media to download from each subreddit, user or other source"`
+	Schedule           string `arg:"--schedule" help:"values: any/round-robin/weighted, order of the posts of multiple sources (any takes them as they are fetched)" default:"any"`
+	Weights            string `arg:"--weights" help:"comma-separated numbers of posts taken from each source in turn by the weighted schedule, e.g. wallpaper=3,u/artist=1 (default: 1)"`
 	MediaMinimalWidth  int    `arg:"-x, --width" help:"minimal content width"`
 	MediaMinimalHeight int    `arg:"-y, --height" help:"minimal content height"`
+	Crossposts         string `arg:"--crossposts" help:"values: keep/skip/dedupe (skip crossposts of already downloaded posts)" default:"keep"`
+	Dedupe             string `arg:"--dedupe" help:"values: off/skip/hardlink/symlink, what to do with files identical to the already saved ones" default:"off"`
+	Sidecar            string `arg:"--sidecar" help:"comma-separated formats of the metadata files written next to the media, values: json/txt/nfo"`
+	VerifyHash         bool   `arg:"--verify-hash" help:"re-read the saved files to verify that their content matches the downloaded data"`
+	EmbedMetadata      bool   `arg:"--embed-metadata" help:"embed the title, author, subreddit and permalink into the saved JPEG and PNG images"`
+	Similar            bool   `arg:"--similar" help:"keep only the highest resolution copy of similar images (e.g. resized reposts)"`
+	SimilarDistance    int    `arg:"--similar-distance" help:"maximal Hamming distance of the perceptual hashes of similar images, from 0 to 64" default:"5"`
+
+	ClientID     string `arg:"--client-id,env:REDDIT_CLIENT_ID" help:"reddit app client id, enables OAuth2"`
+	ClientSecret string `arg:"--client-secret,env:REDDIT_CLIENT_SECRET" help:"reddit app client secret (empty for installed apps)" json:"-"`
+	Username     string `arg:"--username,env:REDDIT_USERNAME" help:"reddit username for script apps"`
+	Password     string `arg:"--password,env:REDDIT_PASSWORD" help:"reddit password for script apps" json:"-"`
+	RefreshToken string `arg:"--refresh-token,env:REDDIT_REFRESH_TOKEN" help:"refresh token for installed/web apps" json:"-"`
+	UserAgent    string `arg:"--user-agent" help:"custom User-Agent to send to reddit"`
+
+	RetryAttempts int           `arg:"--retries" help:"total attempts for requests failing with transient errors" default:"4"`
+	RetryDelay    time.Duration `arg:"--retry-delay" help:"delay after the first failed attempt, doubles after each one" default:"500ms"`
+	RetryMaxDelay time.Duration `arg:"--retry-max-delay" help:"maximal delay between the attempts" default:"30s"`
+	RetryJitter   float64       `arg:"--retry-jitter" help:"randomized fraction of the delay, from 0 to 1" default:"0.2"`
+	RetryOn       string        `arg:"--retry-on" help:"comma-separated response statuses to retry, e.g. 5xx,429" default:"5xx,408,429"`
+
+	VideoMaxWidth  int  `arg:"--video-max-width" help:"maximal width of downloaded reddit videos"`
+	VideoMaxHeight int  `arg:"--video-max-height" help:"maximal height of downloaded reddit videos"`
+	FFmpeg         bool `arg:"--ffmpeg" help:"mux reddit videos with ffmpeg, if it is found in PATH"`
 
 	ShowNSFW        bool `arg:"-n, --nsfw" help:"enable if you want to show NSFW content"`
 	VerboseLogging  bool `arg:"-v, --verbose" help:"enable debug logging"`
 	ProgressLogging bool `arg:"-p, --progress" help:"enable current progress logging"`
+
+	History *HistoryCommand `arg:"subcommand:history" help:"list, prune, import or export the download history"`
+	Report  *ReportCommand  `arg:"subcommand:report" help:"list the clusters of similar images in the output directory"`
 }
 
 func main() {
 	var args AppArguments
 	parser := arg.MustParse(&args)
 
+	if args.VerboseLogging {
+		log.Logger = log.Level(zerolog.DebugLevel)
+	} else {
+		log.Logger = log.Level(zerolog.InfoLevel)
+	}
+
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+
+	if args.History != nil {
+		if args.SaveDirectory == "" && args.HistoryPath == "" {
+			parser.Fail("you must provide the output path using -d or --dir, or the history path using --history")
+		}
+		if err := runHistory(&args, os.Stdout); err != nil {
+			log.Fatal().Err(err).Msg("error running the history command")
+		}
+		return
+	}
+
 	if args.SaveDirectory == "" {
 		parser.Fail("you must provide a valid output path using -d or --dir")
 	}
 
-	if args.SubredditList == "" {
-		parser.Fail("you must provide a list of comma-separated subreddits using -r or --subreddits")
+	if args.OutputTemplate != "" {
+		if _, err := ParseOutputTemplate(args.OutputTemplate); err != nil {
+			parser.Fail(err.Error())
+		}
 	}
 
-	if args.MediaCount == 0 {
-		log.Info().Msg("no media requested to download, ending")
-		os.Exit(0)
+	for _, format := range args.sidecarFormats() {
+		switch format {
+		case SidecarJSON, SidecarText, SidecarNFO:
+		default:
+			parser.Fail("--sidecar formats must be json, txt or nfo")
+		}
 	}
 
-	if args.VerboseLogging {
-		log.Logger = log.Level(zerolog.DebugLevel)
-	} else {
-		log.Logger = log.Level(zerolog.InfoLevel)
+	if args.SimilarDistance < 0 || args.SimilarDistance > 64 {
+		parser.Fail("--similar-distance must be from 0 to 64")
 	}
 
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	if args.Report != nil {
+		if err := runReport(&args, os.Stdout); err != nil {
+			log.Fatal().Err(err).Msg("error running the report command")
+		}
+		return
+	}
+
+	if args.SubredditList == "" && args.Users == "" && args.Search == "" && !args.Saved && !args.Upvoted {
+		parser.Fail("you must provide a list of comma-separated subreddits using -r or --subreddits, " +
+			"users using -u or --users, a search query using --search, or use --saved or --upvoted")
+	}
+
+	if (args.Saved || args.Upvoted || args.Unsave) && args.ClientID == "" {
+		parser.Fail("you must authenticate as a user (see --client-id) to download the saved or upvoted posts")
+	}
+
+	switch args.ItemKind {
+	case "links", "comments", "all":
+	default:
+		parser.Fail("--kind must be one of links, comments or all")
+	}
+
+	if args.SearchIn != "" && args.Search == "" {
+		parser.Fail("you must provide the search query using --search to search in a subreddit")
+	}
+
+	for _, searchType := range strings.Split(args.SearchType, ",") {
+		switch searchType {
+		case "link", "sr", "user":
+		default:
+			parser.Fail("--search-type must be a comma-separated list of link, sr or user")
+		}
+	}
+
+	if args.ClientID == "" && (args.ClientSecret != "" || args.Username != "" || args.RefreshToken != "") {
+		parser.Fail("you must provide the app client id using --client-id to authenticate")
+	}
+
+	switch args.Crossposts {
+	case "keep", "skip", "dedupe":
+	default:
+		parser.Fail("--crossposts must be one of keep, skip or dedupe")
+	}
+
+	switch args.Dedupe {
+	case DedupeOff, DedupeSkip, DedupeHardlink, DedupeSymlink:
+	default:
+		parser.Fail("--dedupe must be one of off, skip, hardlink or symlink")
+	}
+	if args.EmbedMetadata && args.linksDuplicates() {
+		log.Warn().Msg("the metadata is not embedded into the files deduplicated with links, as they are shared by the posts")
+	}
+
+	if err := args.retryPolicy().Validate(); err != nil {
+		parser.Fail(err.Error())
+	}
+
+	switch args.Schedule {
+	case stream.ScheduleAny, stream.ScheduleRoundRobin, stream.ScheduleWeighted:
+	default:
+		parser.Fail("--schedule must be one of any, round-robin or weighted")
+	}
+	if args.Weights != "" && args.Schedule != stream.ScheduleWeighted {
+		parser.Fail("--weights can only be used with --schedule weighted")
+	}
+	if _, err := args.weights(); err != nil {
+		parser.Fail(err.Error())
+	}
+
+	if args.MediaCount < 0 || args.CountPerSource < 0 {
+		parser.Fail("--count and --count-per-source must not be negative")
+	}
+	if args.MediaCount == 0 && args.CountPerSource == 0 {
+		log.Info().Msg("no media requested to download, ending")
+		os.Exit(0)
+	}
 
 	log.Debug().Any("app_arguments", args).Send()
 
-	ctx := context.Background()
-	if err := run(ctx, &args); err != nil {
+	// The first signal stops the downloads gracefully, the second one kills the app.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	err := run(ctx, &args)
+	if ctx.Err() != nil {
+		os.Exit(exitInterrupted)
+	}
+	if err != nil {
 		log.Fatal().Err(err).Msg("error running the app")
 	}
 }
@@ -63,3 +223,100 @@ func main() {
 func run(ctx context.Context, args *AppArguments) error {
 	return NewSaver(args, runtime.NumCPU(), runtime.NumCPU()*2).Run(ctx)
 }
+
+// newClient creates the reddit client, authenticated if the credentials were provided.
+func newClient(args *AppArguments) *api.Client {
+	client := api.DefaultClient().
+		WithUserAgent(args.UserAgent).
+		WithRetryPolicy(args.retryPolicy()).
+		WithVideoOptions(args.videoOptions())
+	if args.ClientID == "" {
+		return client
+	}
+
+	return client.WithCredentials(api.Credentials{
+		ClientID:     args.ClientID,
+		ClientSecret: args.ClientSecret,
+		Username:     args.Username,
+		Password:     args.Password,
+		RefreshToken: args.RefreshToken,
+	})
+}
+
+// videoOptions returns the reddit video options, ffmpeg is only used if it is installed.
+func (args *AppArguments) videoOptions() api.VideoOptions {
+	opts := api.VideoOptions{MaxWidth: args.VideoMaxWidth, MaxHeight: args.VideoMaxHeight}
+	if args.FFmpeg {
+		path, err := exec.LookPath("ffmpeg")
+		if err != nil {
+			log.Warn().Err(err).Msg("ffmpeg was not found, muxing videos without it")
+			return opts
+		}
+		opts.FFmpeg = path
+	}
+	return opts
+}
+
+func (args *AppArguments) retryPolicy() api.RetryPolicy {
+	return api.RetryPolicy{
+		RetryStatuses: strings.Split(args.RetryOn, ","),
+		MaxAttempts:   args.RetryAttempts,
+		BaseDelay:     args.RetryDelay,
+		MaxDelay:      args.RetryMaxDelay,
+		Jitter:        args.RetryJitter,
+	}
+}
+
+// weights parses the weights of the sources, keyed by the source strings of the stream (e.g. "r/wallpaper").
+// The names are matched with the downloaded sources regardless of the case, the other names are rejected.
+func (args *AppArguments) weights() (map[string]int, error) {
+	var (
+		sources = make(map[string]string)
+		names   []string
+	)
+	for _, source := range args.sources() {
+		sources[strings.ToLower(source.String())] = source.String()
+		names = append(names, source.String())
+	}
+
+	weights := make(map[string]int)
+	for _, entry := range splitList(args.Weights) {
+		name, value, ok := strings.Cut(entry, "=")
+		weight, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || err != nil || weight < 1 {
+			return nil, fmt.Errorf("invalid --weights entry %q, expected {source}={positive number}", entry)
+		}
+		key, ok := sources[strings.ToLower(sourceKey(name))]
+		if !ok {
+			return nil, fmt.Errorf("--weights source %q is not one of the sources: %s", strings.TrimSpace(name), strings.Join(names, ", "))
+		}
+		weights[key] = weight
+	}
+	return weights, nil
+}
+
+// sources returns the sources the posts are downloaded from.
+func (args *AppArguments) sources() []stream.Source {
+	opts := stream.Options{
+		Subreddits:      splitList(args.SubredditList),
+		Users:           splitList(args.Users),
+		Search:          args.Search,
+		SearchSubreddit: args.SearchIn,
+		Saved:           args.Saved,
+		Upvoted:         args.Upvoted,
+	}
+	return opts.Sources()
+}
+
+// sourceKey returns the stream source string of the name: subreddits may be written without the "r/" prefix,
+// users (or their multireddits) with the "user/" one, and the saved and upvoted listings are "me/saved" and "me/upvoted".
+func sourceKey(name string) string {
+	name = strings.Trim(strings.TrimSpace(name), "/")
+	if user, ok := strings.CutPrefix(name, "user/"); ok {
+		name = "u/" + user
+	}
+	if strings.HasPrefix(name, "u/") || strings.HasPrefix(name, "me/") {
+		return name
+	}
+	return stream.ParseSubreddit(name).String()
+}
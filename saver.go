@@ -1,11 +1,13 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"path/filepath"
@@ -15,26 +17,41 @@ import (
 	"time"
 
 	"github.com/handsomefox/redditdl/api"
+	"github.com/handsomefox/redditdl/history"
+	"github.com/handsomefox/redditdl/imagemeta"
 	"github.com/handsomefox/redditdl/stream"
 	"github.com/rs/zerolog/log"
 )
 
-type SaverItem struct {
-	Data *api.Item
-	Path string
-}
-
 type Saver struct {
 	skipped atomic.Int64
 	queued  atomic.Int64
 	saved   atomic.Int64
 	failed  atomic.Int64
+	// writing is the amount of files being written at the moment.
+	writing atomic.Int64
 
-	saveCh     chan SaverItem
-	downloadCh chan *api.Post
+	downloadCh chan *stream.Result
 
 	client *api.Client
 	args   *AppArguments
+	// cancel aborts the run with the provided cause.
+	cancel context.CancelCauseFunc
+	// seen contains the names of the original posts, whose media was downloaded in this run.
+	seen sync.Map
+	// history contains the posts downloaded by the previous runs and the hashes of the saved files,
+	// it's nil if neither the history nor the deduplication is enabled.
+	history *history.Store
+	// template is the parsed output template, it's nil if the default paths are used.
+	template *OutputTemplate
+	// dedupeMu serializes the lookups and updates of the file and image hashes.
+	dedupeMu sync.Mutex
+	// sourceFailures is the number of consecutive fetch failures of each source, it's only used by dispatch.
+	sourceFailures map[stream.Source]int
+	// quotas count the files saved from each source, if --count-per-source is set.
+	quotas sync.Map // stream.Source -> *quota
+	// feed is the stream of the posts, it's set once it's started.
+	feed *stream.Stream
 
 	workerCount int
 	bufferSize  int
@@ -55,16 +72,32 @@ func NewSaver(args *AppArguments, workerCount int, bufferSize int) *Saver {
 		queued:      atomic.Int64{},
 		saved:       atomic.Int64{},
 		failed:      atomic.Int64{},
-		saveCh:      make(chan SaverItem, bufferSize),
-		downloadCh:  make(chan *api.Post, bufferSize),
+		writing:     atomic.Int64{},
+		downloadCh:  make(chan *stream.Result, bufferSize),
 		workerCount: workerCount,
 		bufferSize:  bufferSize,
-		client:      api.DefaultClient(),
+		client:      newClient(args),
 		args:        args,
 	}
 }
 
 func (s *Saver) Run(ctx context.Context) error {
+	ctx, s.cancel = context.WithCancelCause(ctx)
+	defer s.cancel(nil)
+
+	if s.args.OutputTemplate != "" {
+		template, err := ParseOutputTemplate(s.args.OutputTemplate)
+		if err != nil {
+			return err
+		}
+		s.template = template
+	}
+
+	historyPath, err := filepath.Abs(s.args.historyPath())
+	if err != nil {
+		return err
+	}
+
 	if err := ChdirOrCreate(s.args.SaveDirectory, true); err != nil {
 		return err
 	}
@@ -73,73 +106,158 @@ func (s *Saver) Run(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	subreddits := s.prepareSubreddits(wd)
 
-	s.saveCh = make(chan SaverItem, s.bufferSize)
-	go func() {
-		defer close(s.saveCh)
-		s.saveLoop()
-	}()
+	if !s.args.NoHistory || s.args.Dedupe != DedupeOff || s.args.Similar {
+		s.history, err = history.Open(historyPath)
+		if err != nil {
+			return fmt.Errorf("failed to open the download history: %w", err)
+		}
+		defer s.history.Close()
+	}
+	if s.history != nil && s.args.Dedupe != DedupeOff && s.args.Dedupe != "" {
+		if err := s.indexExistingFiles(wd); err != nil {
+			return fmt.Errorf("failed to index the existing files: %w", err)
+		}
+	}
+	subreddits := s.prepareSubreddits(wd)
 
-	s.downloadCh = make(chan *api.Post, s.bufferSize)
-	once := new(sync.Once)
+	s.downloadCh = make(chan *stream.Result, s.bufferSize)
+	var workers sync.WaitGroup
 	for i := 0; i < s.workerCount; i++ {
+		workers.Add(1)
 		go func() {
-			defer once.Do(func() {
-				close(s.downloadCh)
-			})
+			defer workers.Done()
 			s.downloadLoop(ctx, wd)
 		}()
 	}
 
-	stream, err := stream.New(s.client, s.argsAsOpts(subreddits...), s.bufferSize)
+	feed, err := stream.New(s.client, s.argsAsOpts(subreddits, splitList(s.args.Users)), s.bufferSize)
 	if err != nil {
+		close(s.downloadCh)
 		return err
 	}
 
 	if s.args.ProgressLogging {
-		go s.progressLoop()
+		go s.progressLoop(ctx)
 	}
 
-	results, err := stream.Start()
-	if err != nil {
-		return err
+	// The stream is stopped once enough media is saved, its channel is drained so that no worker is left behind.
+	streamCtx, stopStream := context.WithCancel(ctx)
+	results := feed.Start(streamCtx)
+	s.feed = feed
+	s.dispatch(ctx, feed, results)
+	stopStream()
+	for range results {
 	}
 
-	defer func() {
-		go stream.Close()
-	}()
-	for !stream.Continue() && s.saved.Load() < s.args.MediaCount {
-		res, ok := <-results
-		if !ok {
-			log.Info().Msg("stream has finished")
-			break
+	// The downloads in progress are finished, or interrupted (keeping the partial files) if the context is cancelled.
+	close(s.downloadCh)
+	workers.Wait()
+
+	if !s.args.VerboseLogging {
+		fmt.Println()
+	}
+	s.logSummary(ctx)
+
+	return context.Cause(ctx)
+}
+
+// dispatch sends the results of the stream to the download workers, until the requested media count is saved,
+// the stream is finished, or the context is cancelled.
+func (s *Saver) dispatch(ctx context.Context, feed *stream.Stream, results <-chan *stream.Result) {
+	s.sourceFailures = make(map[stream.Source]int)
+	for !s.countReached() {
+		var res *stream.Result
+		select {
+		case r, ok := <-results:
+			if !ok {
+				if ctx.Err() == nil {
+					log.Info().Msg("stream has finished")
+				}
+				return
+			}
+			res = r
+		case <-ctx.Done():
+			return
 		}
-		if res == nil {
+		if res.Err != nil {
+			s.handleSourceError(feed, res)
 			continue
 		}
-		s.downloadCh <- res
-		s.queued.Add(1)
+		delete(s.sourceFailures, res.Source)
+		if q := s.quota(res.Source); q != nil && q.saved.Load() >= s.args.CountPerSource {
+			continue // The source is being stopped.
+		}
+
+		select {
+		case s.downloadCh <- res:
+			s.queued.Add(1)
+		case <-ctx.Done():
+			return
+		}
 
 		if s.queued.Load() > int64(s.bufferSize)+10 { // Worst-case, we will queue at least 10 items at a time.
-			time.Sleep(500 * time.Millisecond)
+			select {
+			case <-time.After(500 * time.Millisecond):
+			case <-ctx.Done():
+				return
+			}
 		}
 	}
+}
 
-	if !s.args.VerboseLogging {
-		fmt.Println()
+// maxSourceFailures is the number of consecutive fetch failures after which a source is stopped.
+const maxSourceFailures = 3
+
+// handleSourceError logs the failure to fetch the posts of the source,
+// and stops the source if it keeps failing, the other sources are fetched as usual.
+func (s *Saver) handleSourceError(feed *stream.Stream, res *stream.Result) {
+	switch {
+	case errors.Is(res.Err, context.Canceled):
+		log.Debug().Err(res.Err).Stringer("source", res.Source).Msg("fetch was interrupted")
+	case errors.Is(res.Err, api.ErrNotFound), errors.Is(res.Err, api.ErrForbidden), errors.Is(res.Err, api.ErrUnauthorized):
+		// The stream has stopped the source already.
+		log.Err(res.Err).Stringer("source", res.Source).Msg("source is unavailable")
+	default:
+		s.sourceFailures[res.Source]++
+		failures := s.sourceFailures[res.Source]
+		if failures >= maxSourceFailures {
+			log.Err(res.Err).Stringer("source", res.Source).Str("cursor", res.Cursor).Int("failures", failures).
+				Msg("failed to fetch the posts repeatedly, stopping the source")
+			feed.Stop(res.Source)
+			return
+		}
+		log.Warn().Err(res.Err).Stringer("source", res.Source).Str("cursor", res.Cursor).Int("failures", failures).
+			Msg("failed to fetch the posts, retrying")
 	}
-	log.Info().Int64("total", s.saved.Load()).Msg("Finished downloading")
+}
 
-	return nil
+// logSummary logs the counters of the run.
+func (s *Saver) logSummary(ctx context.Context) {
+	event := log.Info()
+	msg := "Finished downloading"
+	if ctx.Err() != nil && !errors.Is(context.Cause(ctx), api.ErrUnauthorized) {
+		event = log.Warn()
+		msg = "Interrupted, the partially downloaded files are kept to be resumed"
+	}
+	event.
+		Int64("total", s.saved.Load()).
+		Int64("failed", s.failed.Load()).
+		Int64("skipped", s.skipped.Load()).
+		Msg(msg)
 }
 
+// prepareSubreddits creates the folders of the subreddits in the list.
+// Combined subreddits and multireddits have no folders, their posts are sorted by subreddit once they're downloaded.
 func (s *Saver) prepareSubreddits(wd string) []string {
-	subreddits := strings.Split(s.args.SubredditList, ",")
+	subreddits := splitList(s.args.SubredditList)
 	for i := 0; i < len(subreddits); i++ {
-		subreddits[i] = strings.TrimSpace(subreddits[i])
 		log.Debug().Str("subreddit", subreddits[i]).Msg("adding subreddit")
-		dir := filepath.Join(wd, strings.ToLower(subreddits[i]))
+		source := stream.ParseSubreddit(subreddits[i])
+		if source.Kind != stream.SourceSubreddit || strings.Contains(source.Name, "+") {
+			continue
+		}
+		dir := filepath.Join(wd, strings.ToLower(source.Name))
 		if err := os.Mkdir(dir, os.ModePerm); err != nil {
 			if !errors.Is(err, os.ErrExist) {
 				log.Err(err).Send()
@@ -151,58 +269,329 @@ func (s *Saver) prepareSubreddits(wd string) []string {
 	return subreddits
 }
 
-func (s *Saver) argsAsOpts(subreddits ...string) stream.Options {
+// splitList splits a comma-separated list, omitting the empty values.
+func splitList(list string) []string {
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func (s *Saver) argsAsOpts(subreddits, users []string) stream.Options {
+	weights, _ := s.args.weights() // The arguments are validated.
 	return stream.Options{
-		ContentType: s.args.SubredditContentType,
-		Sort:        s.args.SubredditSort,
-		Timeframe:   s.args.SubredditTimeframe,
-		Subreddits:  subreddits,
-		ShowNSFW:    s.args.ShowNSFW,
+		ContentType:     s.args.SubredditContentType,
+		Sort:            s.args.SubredditSort,
+		Timeframe:       s.args.SubredditTimeframe,
+		Subreddits:      subreddits,
+		Users:           users,
+		Search:          s.args.Search,
+		SearchSubreddit: s.args.SearchIn,
+		SearchSort:      s.args.SearchSort,
+		SearchType:      s.args.SearchType,
+		Saved:           s.args.Saved,
+		Upvoted:         s.args.Upvoted,
+		ItemKind:        s.args.ItemKind,
+		ShowNSFW:        s.args.ShowNSFW,
+		Schedule:        s.args.Schedule,
+		Weights:         weights,
 	}
 }
 
+// downloadLoop downloads the posts until the channel is closed, the queued posts are dropped once the context is cancelled.
 func (s *Saver) downloadLoop(ctx context.Context, wd string) {
-	for post := range s.downloadCh {
-		if !s.isEligibleForSaving(post) {
-			log.Debug().Msg("skipped an item")
+	for res := range s.downloadCh {
+		if ctx.Err() == nil {
+			s.downloadPost(ctx, wd, res)
+		}
+		s.queued.Add(-1)
+	}
+}
+
+// downloadPost saves the media of the post, galleries may contain multiple items.
+func (s *Saver) downloadPost(ctx context.Context, wd string, res *stream.Result) {
+	post := res.Post
+	if !s.isEligibleForSaving(post) {
+		log.Debug().Msg("skipped an item")
+		s.skipped.Add(1)
+		return
+	}
+
+	saved, failed := false, false
+	if s.args.Crossposts == "dedupe" {
+		// Crossposts and their originals share the media, only the first one is downloaded.
+		if _, seen := s.seen.LoadOrStore(post.OriginalName(), struct{}{}); seen {
+			log.Debug().Str("post", post.OriginalName()).Msg("skipped an already downloaded crosspost")
 			s.skipped.Add(1)
-			s.queued.Store(s.queued.Load() - 1)
-			continue
+			return
 		}
+		defer func() {
+			if !saved {
+				s.seen.Delete(post.OriginalName()) // Let another copy of the post try.
+			}
+		}()
+	}
+	if s.downloadedBefore(post) {
+		log.Debug().Str("post", post.Name()).Msg("skipped a post from the history")
+		s.skipped.Add(1)
+		s.unsave(ctx, res)
+		return
+	}
+	var (
+		paths []string
+		// incomplete is set once the count is reached before all the items of the post are saved.
+		incomplete bool
+	)
 
-		item, err := s.client.Subreddit.PostToItem(ctx, post)
-		if err != nil {
-			log.Err(err).Msg("failed to convert a post to an item")
-			s.failed.Add(1)
-			s.queued.Store(s.queued.Load() - 1)
+	items, err := s.client.Subreddit.PostToItems(ctx, post)
+	if err != nil {
+		s.handleFetchError(err)
+		return
+	}
+
+	for _, item := range items {
+		if !s.isItemEligibleForSaving(item) {
+			log.Debug().Str("url", item.URL).Msg("skipped an item")
+			s.skipped.Add(1)
 			continue
 		}
-		// item path is:
-		// {working_directory}/{subreddit}/{item_name}.{item_extension}
-		filename, err := NewFormattedFilename(item.Name, item.Extension)
+		rel, err := s.itemPath(wd, res, item)
 		if err != nil {
 			log.Err(err).Str("item_name", item.Name).Msg("failed to save item")
 			s.failed.Add(1)
-			s.queued.Store(s.queued.Load() - 1)
+			failed = true
 			continue
 		}
-		p := filepath.Join(wd, strings.ToLower(post.Data.Subreddit), filename)
-		s.saveCh <- SaverItem{Data: item, Path: p}
+		p := filepath.Join(wd, rel)
+		if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+			log.Err(err).Str("dir", filepath.Dir(p)).Msg("failed to create the directory")
+			s.failed.Add(1)
+			failed = true
+			continue
+		}
+
+		q := s.quota(res.Source)
+		if !s.reserve(q) {
+			incomplete = true
+			break
+		}
+		if err := s.WriteFile(ctx, p, item); err != nil {
+			s.handleFetchError(err)
+			failed = true
+		} else if original, removed := s.findDuplicate(wd, p); removed {
+			log.Debug().Str("path", p).Str("original", original).Msg("skipped a duplicate file")
+			s.skipped.Add(1)
+			paths = append(paths, original)
+		} else {
+			s.countSaved(res.Source, q)
+			saved = true
+			paths = append(paths, rel)
+			s.finalize(p, post, item)
+		}
+		s.release(q)
+	}
+
+	// The saved items of an incomplete post are recorded too, they would be downloaded again otherwise.
+	if len(paths) > 0 && !failed {
+		s.record(post, items, paths)
+		if !incomplete {
+			s.unsave(ctx, res)
+		}
 	}
 }
 
-func (s *Saver) saveLoop() {
-	for item := range s.saveCh {
-		if s.saved.Load() >= s.args.MediaCount {
-			continue
+// finalize adds the metadata of the post to the saved file: it's embedded into the file, and written next to it.
+// The metadata isn't embedded if the files are deduplicated with links, as the content is shared by the posts,
+// the later ones would carry the metadata of the first one.
+func (s *Saver) finalize(path string, post *api.Post, item *api.Item) {
+	s.writeSidecars(path, post, item)
+	if !s.args.EmbedMetadata || s.args.linksDuplicates() {
+		return
+	}
+	if err := imagemeta.Embed(path, newMetadata(post, item, path).fields()); err != nil {
+		if errors.Is(err, imagemeta.ErrUnsupportedFormat) {
+			log.Debug().Err(err).Str("path", path).Msg("skipped embedding the metadata")
+			return
 		}
-		if err := s.WriteFile(item.Path, item.Data.Bytes); err != nil {
-			s.failed.Add(1)
-			log.Err(err).Msg("failed to write file to disk")
-		} else {
-			s.saved.Add(1)
+		log.Err(err).Str("path", path).Msg("failed to embed the metadata")
+		s.failed.Add(1)
+	}
+}
+
+// linksDuplicates reports whether the duplicate files are replaced with links to the original ones.
+func (args *AppArguments) linksDuplicates() bool {
+	return args.Dedupe == DedupeHardlink || args.Dedupe == DedupeSymlink
+}
+
+// findDuplicate deduplicates the saved file, and reports whether it was removed as a duplicate.
+// The returned path of the original is empty, unless the file was removed or replaced with a link to it.
+func (s *Saver) findDuplicate(wd, path string) (string, bool) {
+	if original, ok := s.deduplicate(wd, path); ok {
+		// Linked files are the same image, there is nothing to compare.
+		return original, s.args.Dedupe == DedupeSkip
+	}
+	return s.keepBestCopy(wd, path)
+}
+
+// downloadedBefore reports whether the post, or its media, is in the download history.
+func (s *Saver) downloadedBefore(post *api.Post) bool {
+	if s.history == nil || s.args.NoHistory {
+		return false
+	}
+	seen, err := s.history.Seen(post.Name(), post.URL())
+	if err != nil {
+		log.Err(err).Str("post", post.Name()).Msg("failed to check the download history")
+		return false
+	}
+	return seen
+}
+
+// record adds the downloaded post to the history, paths are relative to the working directory.
+func (s *Saver) record(post *api.Post, items []*api.Item, paths []string) {
+	if s.history == nil || s.args.NoHistory {
+		return
+	}
+	entry := history.Entry{
+		Name:         post.Name(),
+		Subreddit:    post.Subreddit(),
+		Title:        post.Title(),
+		URLs:         []string{post.URL()},
+		Paths:        paths,
+		DownloadedAt: time.Now(),
+	}
+	for _, item := range items {
+		if item.URL != post.URL() {
+			entry.URLs = append(entry.URLs, item.URL)
+		}
+	}
+	if err := s.history.Add(entry); err != nil {
+		log.Err(err).Str("post", post.Name()).Msg("failed to add the post to the download history")
+	}
+}
+
+// unsave removes the post from the saved list of the user, if it was requested.
+func (s *Saver) unsave(ctx context.Context, res *stream.Result) {
+	if !s.args.Unsave || res.Source.Kind != stream.SourceSaved {
+		return
+	}
+	// The saved list works as a download queue, the post is done.
+	if err := s.client.Unsave(ctx, res.Post.Name()); err != nil {
+		log.Err(err).Str("post", res.Post.Name()).Msg("failed to unsave the post")
+	}
+}
+
+// itemPath returns the path of the item, relative to the working directory.
+// Without the output template, the path is:
+// {folder}/{item_name}.{item_extension}
+func (s *Saver) itemPath(wd string, res *stream.Result, item *api.Item) (string, error) {
+	if s.template != nil {
+		return s.template.Path(wd, res.Post, item)
+	}
+	filename, err := NewFormattedFilename(item.Name, item.Extension)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(folder(res), filename), nil
+}
+
+// folder returns the directory of the post media, relative to the working directory.
+// User submissions are kept together in u_{user}, other posts are sorted by their subreddit.
+func folder(res *stream.Result) string {
+	if res.Source.Kind == stream.SourceUser {
+		return "u_" + strings.ToLower(res.Source.Name)
+	}
+	return strings.ToLower(res.Post.Subreddit())
+}
+
+// quota counts the files saved from a source, and the ones being written.
+type quota struct {
+	saved   atomic.Int64
+	writing atomic.Int64
+}
+
+// quota returns the quota of the source, or nil if the sources have no quotas.
+func (s *Saver) quota(source stream.Source) *quota {
+	if s.args.CountPerSource == 0 {
+		return nil
+	}
+	q, _ := s.quotas.LoadOrStore(source, &quota{})
+	return q.(*quota)
+}
+
+// countReached reports whether the requested media count is saved, there's no limit if it's zero.
+func (s *Saver) countReached() bool {
+	return s.args.MediaCount > 0 && s.saved.Load() >= s.args.MediaCount
+}
+
+// reserve reports whether another file can be written without exceeding the requested media count,
+// and the quota of its source, if it's not nil.
+// The caller has to call release after it has finished writing.
+func (s *Saver) reserve(q *quota) bool {
+	if !reserve(&s.saved, &s.writing, s.args.MediaCount) {
+		return false
+	}
+	if q != nil && !reserve(&q.saved, &q.writing, s.args.CountPerSource) {
+		s.writing.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (s *Saver) release(q *quota) {
+	s.writing.Add(-1)
+	if q != nil {
+		q.writing.Add(-1)
+	}
+}
+
+// reserve increments the writing counter, unless the saved and the written files would exceed the limit.
+// There's no limit if it's zero.
+func reserve(saved, writing *atomic.Int64, limit int64) bool {
+	for {
+		n := writing.Load()
+		if limit > 0 && saved.Load()+n >= limit {
+			return false
+		}
+		if writing.CompareAndSwap(n, n+1) {
+			return true
 		}
-		s.queued.Store(s.queued.Load() - 1)
+	}
+}
+
+// countSaved counts the saved file, and stops fetching the posts of the source once its quota is met.
+func (s *Saver) countSaved(source stream.Source, q *quota) {
+	s.saved.Add(1)
+	if q == nil || q.saved.Add(1) != s.args.CountPerSource {
+		return
+	}
+	log.Info().Stringer("source", source).Int64("count", s.args.CountPerSource).Msg("source quota is met, stopping it")
+	if s.feed != nil {
+		s.feed.Stop(source)
+	}
+}
+
+// handleFetchError decides what to do with a post, whose media could not be fetched.
+// Missing, forbidden and unexpected (e.g. HTML error pages) media is skipped,
+// transient errors are failures, as the client has already retried them,
+// and unauthorized requests abort the run, because all the following ones would fail too.
+// Interrupted downloads are neither, their partial files are kept.
+func (s *Saver) handleFetchError(err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		log.Debug().Err(err).Msg("download was interrupted")
+	case errors.Is(err, api.ErrNotFound), errors.Is(err, api.ErrForbidden), errors.Is(err, api.ErrUnexpectedContentType):
+		log.Debug().Err(err).Msg("skipped unavailable media")
+		s.skipped.Add(1)
+	case errors.Is(err, api.ErrUnauthorized):
+		log.Err(err).Msg("request was not authorized, aborting")
+		s.failed.Add(1)
+		s.cancel(err)
+	default:
+		log.Err(err).Msg("failed to save the media")
+		s.failed.Add(1)
 	}
 }
 
@@ -219,7 +608,7 @@ func coloredString(c color, s string) string {
 	return "\x1B[" + fmt.Sprint(c) + "m" + s + "\033[0m"
 }
 
-func (s *Saver) progressLoop() {
+func (s *Saver) progressLoop(ctx context.Context) {
 	var (
 		lastTotal = int64(0)
 		stringf   = "Download status: " +
@@ -227,6 +616,8 @@ func (s *Saver) progressLoop() {
 			coloredString(Green, "Saved") + "=%d; " +
 			coloredString(Red, "Failed") + "=%d; " +
 			coloredString(Yellow, "Skipped") + "=%d;"
+		// Shown only when reddit reports the rate limits
+		limitf = " Requests left=%.0f (reset in %s);"
 		// Specified format string for printing
 		// Function used for printing (by default, zerolog)
 		progprint = func(msg string) { log.Info().Msg(msg) }
@@ -234,12 +625,11 @@ func (s *Saver) progressLoop() {
 
 	if !s.args.VerboseLogging {
 		// if no logging will be done, we can take control and print in a single line.
-		stringf += "\r"
 		// Use package fmt for carriage return working correctly
-		progprint = func(msg string) { fmt.Print(msg) }
+		progprint = func(msg string) { fmt.Print(msg + "\r") }
 	}
 
-	for s.saved.Load()+s.failed.Load() < s.args.MediaCount {
+	for ctx.Err() == nil && (s.args.MediaCount == 0 || s.saved.Load()+s.failed.Load() < s.args.MediaCount) {
 		saved := s.saved.Load()
 		failed := s.failed.Load()
 		queued := s.queued.Load()
@@ -247,7 +637,11 @@ func (s *Saver) progressLoop() {
 		total := s.saved.Load() + failed + queued + skipped
 
 		if lastTotal < total {
-			progprint(fmt.Sprintf(stringf, queued, saved, failed, skipped))
+			msg := fmt.Sprintf(stringf, queued, saved, failed, skipped)
+			if limit := s.client.RateLimit(); limit.Known() {
+				msg += fmt.Sprintf(limitf, limit.Remaining, time.Until(limit.Reset).Round(time.Second))
+			}
+			progprint(msg)
 			lastTotal = total
 		}
 		// No need to update all the time
@@ -255,29 +649,218 @@ func (s *Saver) progressLoop() {
 	}
 }
 
-func (s *Saver) WriteFile(path string, b []byte) error {
-	file, err := os.Create(path)
+// WriteFile downloads the item to a temporary file next to the path,
+// and then renames it, so that the path never contains a partially written file.
+//
+// If the download fails, the temporary file is kept as {path}.part along with its state in {path}.part.json,
+// so the next attempt to write the same item to the same path resumes the download.
+func (s *Saver) WriteFile(ctx context.Context, path string, item *api.Item) error {
+	var (
+		partPath  = path + ".part"
+		statePath = partPath + ".json"
+	)
+
+	file, state, err := openPartialFile(partPath, statePath, item)
+	if err != nil {
+		return err
+	}
+	if state.Written > 0 {
+		log.Debug().Int64("written_bytes", state.Written).Str("path", partPath).Msg("resuming download")
+	}
+
+	var (
+		dst    api.File = file
+		hashed *hashingFile
+	)
+	if s.args.VerifyHash {
+		hashed = &hashingFile{File: file}
+		dst = hashed
+	}
+	err = s.client.Subreddit.Resume(ctx, item, dst, state)
+	if err == nil {
+		// The file is renamed into place only once it's durably on disk.
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = verifyFile(partPath, state, hashed)
+	}
+	if err == nil {
+		err = os.Rename(partPath, path)
+	}
 	if err != nil {
-		log.Debug().Msg(path)
+		// Keep what was downloaded, unless the media is gone or corrupted, so that the next attempt can resume it.
+		gone := errors.Is(err, api.ErrNotFound) || errors.Is(err, api.ErrForbidden) || errors.Is(err, api.ErrUnexpectedContentType) ||
+			errors.Is(err, ErrVerification)
+		if state.Written > 0 && !gone {
+			if stateErr := writePartialState(statePath, state); stateErr == nil {
+				return err
+			}
+		}
+		removePartialFile(partPath, statePath)
 		return err
 	}
-	defer file.Close()
+	removePartialFile(statePath)
+
+	log.Debug().Int64("written_bytes", state.Written).Str("path", path).Msg("wrote to disk")
+
+	return nil
+}
 
-	fw := bufio.NewWriter(file)
-	defer fw.Flush()
+var ErrVerification = errors.New("saved file does not match the downloaded data")
 
-	br := bytes.NewBuffer(b)
+// verifyFile checks that the file has the size of the downloaded data,
+// and if the hash of the downloaded data is known, that the file content has the same hash.
+func verifyFile(path string, state *api.DownloadState, hashed *hashingFile) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if stat.Size() != state.Written || state.Size > 0 && stat.Size() != state.Size {
+		return fmt.Errorf("%w: the file has %d bytes, downloaded %d out of %d", ErrVerification, stat.Size(), state.Written, state.Size)
+	}
+	if hashed == nil {
+		return nil
+	}
 
-	n, err := io.Copy(fw, br)
+	want, err := hashed.Sum(state.Written)
+	if err != nil {
+		return err
+	}
+	got, err := hashFile(path)
 	if err != nil {
 		return err
 	}
+	if got != want {
+		return fmt.Errorf("%w: the file hash is %s, expected %s", ErrVerification, got, want)
+	}
+	return nil
+}
 
-	log.Debug().Int64("written_bytes", n).Str("path", path).Msg("wrote to disk")
+// hashingFile computes the SHA-256 hash of the data written to the file.
+// Truncating the file (e.g. to resume a download, or to restart it) rewinds the hash accordingly.
+type hashingFile struct {
+	*os.File
+	hash   hash.Hash
+	hashed int64
+}
 
+func (f *hashingFile) Write(p []byte) (int, error) {
+	if f.hash == nil {
+		if err := f.rehash(0); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.File.Write(p)
+	f.hash.Write(p[:n])
+	f.hashed += int64(n)
+	return n, err
+}
+
+func (f *hashingFile) Truncate(size int64) error {
+	if err := f.File.Truncate(size); err != nil {
+		return err
+	}
+	if f.hash == nil || size != f.hashed {
+		return f.rehash(size)
+	}
 	return nil
 }
 
+// rehash hashes the first size bytes of the file, which were written before.
+func (f *hashingFile) rehash(size int64) error {
+	f.hash, f.hashed = sha256.New(), size
+	_, err := io.Copy(f.hash, io.NewSectionReader(f.File, 0, size))
+	return err
+}
+
+// Sum returns the hex-encoded hash of the first size bytes of the file.
+// Usually, they are hashed as they are written, the file is only read if nothing was written in this attempt.
+func (f *hashingFile) Sum(size int64) (string, error) {
+	if f.hash == nil || f.hashed != size {
+		if err := f.rehash(size); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(f.hash.Sum(nil)), nil
+}
+
+// openPartialFile opens the file left by a previous failed download of the item, or creates a new one.
+func openPartialFile(partPath, statePath string, item *api.Item) (*os.File, *api.DownloadState, error) {
+	state := &api.DownloadState{URL: item.URL}
+
+	// The state url may differ from the item url (e.g. for videos downloaded from a manifest),
+	// Resume starts from scratch if the state belongs to another media.
+	b, err := os.ReadFile(statePath)
+	if err == nil {
+		var saved api.DownloadState
+		if err := json.Unmarshal(b, &saved); err == nil && saved.URL != "" {
+			state = &saved
+		}
+	}
+
+	file, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Only trust the bytes that are actually on disk.
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	if stat.Size() < state.Written {
+		state.Written = stat.Size()
+	}
+
+	return file, state, nil
+}
+
+func writePartialState(statePath string, state *api.DownloadState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(statePath, b)
+}
+
+// writeFileAtomic writes the data to a temporary file next to the path, and then renames it,
+// so that the path either has the previous content or all the data.
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails once it's renamed.
+
+	// The temporary files are only readable by the owner.
+	err = tmp.Chmod(0o644)
+	if err == nil {
+		_, err = tmp.Write(b)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func removePartialFile(paths ...string) {
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Err(err).Str("path", path).Msg("failed to remove a partial file")
+		}
+	}
+}
+
 // isEligibleForSaving checks if the post goes through all the specified parameters by the user.
 func (s *Saver) isEligibleForSaving(p *api.Post) bool {
 	if p == nil {
@@ -298,19 +881,29 @@ func (s *Saver) isEligibleForSaving(p *api.Post) bool {
 		return false
 	}
 
-	w, h := p.Dimensions()
-	if w < s.args.MediaMinimalWidth && h < s.args.MediaMinimalHeight {
-		log.Debug().Int("width", w).Int("height", h).Msg("unfit dimensions")
+	if s.args.Crossposts == "skip" && p.IsCrosspost() {
+		log.Debug().Str("crosspost_parent", p.OriginalName()).Msg("filtered out crosspost")
 		return false
 	}
 
-	if p.Data.Over18 && !s.args.ShowNSFW {
+	if p.IsNSFW() && !s.args.ShowNSFW {
 		log.Debug().Msg("filtered out NSFW")
 		return false
 	}
 
+	return true
+}
+
+// isItemEligibleForSaving checks if the media goes through the dimension and orientation parameters.
+// The check is done per item, because the images of a gallery may differ.
+func (s *Saver) isItemEligibleForSaving(item *api.Item) bool {
+	if item.Width < s.args.MediaMinimalWidth && item.Height < s.args.MediaMinimalHeight {
+		log.Debug().Int("width", item.Width).Int("height", item.Height).Msg("unfit dimensions")
+		return false
+	}
+
 	if s.args.MediaOrientation != "all" {
-		if s.args.MediaOrientation != p.Orientation() {
+		if s.args.MediaOrientation != item.Orientation {
 			log.Debug().Msg("filtered out by orientation")
 			return false
 		}
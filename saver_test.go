@@ -1,9 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/handsomefox/redditdl/api"
+	"github.com/handsomefox/redditdl/history"
+	"github.com/handsomefox/redditdl/stream"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
@@ -24,6 +39,419 @@ func TestDownload25(t *testing.T) {
 	assert.NoError(t, NewSaver(args, 1, 1).Run(context.TODO()))
 }
 
+func TestWriteFile(t *testing.T) {
+	media := bytes.Repeat([]byte("media"), 1<<20)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, err := w.Write(media)
+		assert.NoError(t, err)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dir := t.TempDir()
+	s := NewSaver(defaultArgs(dir, 1), 1, 1)
+
+	path := filepath.Join(dir, "image.png")
+	assert.NoError(t, s.WriteFile(context.TODO(), path, &api.Item{URL: server.URL + "/image.png"}))
+
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, media, b, "unexpected file contents")
+	assert.False(t, FileExists(path+".part"), "temporary file should be renamed")
+
+	path = filepath.Join(dir, "missing.png")
+	assert.Error(t, s.WriteFile(context.TODO(), path, &api.Item{URL: server.URL + "/missing.png"}))
+	assert.False(t, FileExists(path), "failed download should not be saved")
+	assert.False(t, FileExists(path+".part"), "failed download should be removed")
+}
+
+func TestWriteFileResume(t *testing.T) {
+	media := bytes.Repeat([]byte("media"), 1<<20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "bytes=1000-", r.Header.Get("Range"), "download should be resumed")
+		w.Header().Set("Content-Type", "video/mp4")
+		http.ServeContent(w, r, "video.mp4", time.Time{}, bytes.NewReader(media))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	s := NewSaver(defaultArgs(dir, 1), 1, 1)
+
+	path := filepath.Join(dir, "video.mp4")
+	item := &api.Item{URL: server.URL + "/video.mp4"}
+	assert.NoError(t, os.WriteFile(path+".part", media[:1000], 0o666))
+	assert.NoError(t, writePartialState(path+".part.json", &api.DownloadState{URL: item.URL, Written: 1000}))
+
+	assert.NoError(t, s.WriteFile(context.TODO(), path, item))
+
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(media, b), "unexpected file contents")
+	assert.False(t, FileExists(path+".part"), "temporary file should be renamed")
+	assert.False(t, FileExists(path+".part.json"), "download state should be removed")
+}
+
+func TestWriteFileVerify(t *testing.T) {
+	media := bytes.Repeat([]byte("media"), 1<<20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		http.ServeContent(w, r, "video.mp4", time.Time{}, bytes.NewReader(media))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	args := defaultArgs(dir, 1)
+	args.VerifyHash = true
+	s := NewSaver(args, 1, 1)
+
+	// The bytes downloaded before are hashed from the disk.
+	path := filepath.Join(dir, "video.mp4")
+	item := &api.Item{URL: server.URL + "/video.mp4"}
+	assert.NoError(t, os.WriteFile(path+".part", media[:1000], 0o666))
+	assert.NoError(t, writePartialState(path+".part.json", &api.DownloadState{URL: item.URL, Written: 1000}))
+	assert.NoError(t, s.WriteFile(context.TODO(), path, item))
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(media, b), "unexpected file contents")
+
+	// The file is changed behind the writer's back.
+	path = filepath.Join(dir, "corrupted.mp4")
+	file, err := os.Create(path)
+	assert.NoError(t, err)
+	hashed := &hashingFile{File: file}
+	_, err = hashed.Write(media)
+	assert.NoError(t, err)
+	_, err = file.WriteAt([]byte("corrupted"), 0)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	state := &api.DownloadState{URL: item.URL, Written: int64(len(media)), Size: int64(len(media))}
+	assert.ErrorIs(t, verifyFile(path, state, hashed), ErrVerification)
+	state.Written, state.Size = 1000, 0
+	assert.ErrorIs(t, verifyFile(path, state, nil), ErrVerification, "size mismatch should fail the verification")
+}
+
+func TestDownloadPostCrossposts(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		_, err := w.Write([]byte("image"))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	var original, crosspost api.Post
+	original.Data.Name = "t3_original"
+	original.Data.Subreddit = "wallpaper"
+	original.Data.PostHint = "image"
+	original.Data.URL = server.URL + "/image.png"
+	crosspost.Data.Name = "t3_crosspost"
+	crosspost.Data.Subreddit = "wallpapers"
+	crosspost.Data.CrosspostParent = original.Data.Name
+	crosspost.Data.CrosspostParentList = []api.PostData{original.Data}
+
+	dir := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "wallpaper"), os.ModePerm))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "wallpapers"), os.ModePerm))
+
+	args := defaultArgs(dir, 10)
+	args.Crossposts = "dedupe"
+	s := NewSaver(args, 1, 1)
+	s.downloadPost(context.TODO(), dir, &stream.Result{Post: &original})
+	s.downloadPost(context.TODO(), dir, &stream.Result{Post: &crosspost})
+	assert.Equal(t, int64(1), s.saved.Load())
+	assert.Equal(t, int64(1), s.skipped.Load(), "crosspost of a downloaded post should be skipped")
+	assert.Equal(t, int32(1), requests.Load())
+
+	args.Crossposts = "skip"
+	s = NewSaver(args, 1, 1)
+	s.downloadPost(context.TODO(), dir, &stream.Result{Post: &crosspost})
+	assert.Equal(t, int64(0), s.saved.Load())
+	assert.Equal(t, int64(1), s.skipped.Load(), "crossposts should be skipped")
+
+	args.Crossposts = "keep"
+	s = NewSaver(args, 1, 1)
+	s.downloadPost(context.TODO(), dir, &stream.Result{Post: &crosspost})
+	assert.Equal(t, int64(1), s.saved.Load(), "crosspost should be saved with the original media")
+	assert.True(t, FileExists(filepath.Join(dir, "wallpapers", "image.png")))
+
+	// Only the dedupe mode keeps track of the downloaded originals.
+	failing := original
+	failing.Data.URL = "http://127.0.0.1:0/image.png"
+	s = NewSaver(args, 1, 1)
+	s.client = s.client.WithRetryPolicy(api.RetryPolicy{MaxAttempts: 1})
+	s.seen.Store(original.Data.Name, struct{}{})
+	s.downloadPost(context.TODO(), dir, &stream.Result{Post: &failing})
+	assert.Equal(t, int64(0), s.saved.Load())
+	_, seen := s.seen.Load(original.Data.Name)
+	assert.True(t, seen, "other modes should not forget the downloaded originals")
+}
+
+func TestDownloadPostHistory(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		_, err := w.Write([]byte("image"))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	var post, repost api.Post
+	post.Data.Name = "t3_post"
+	post.Data.Subreddit = "wallpaper"
+	post.Data.PostHint = "image"
+	post.Data.URL = server.URL + "/image.png"
+	repost.Data = post.Data
+	repost.Data.Name = "t3_repost"
+
+	dir := t.TempDir()
+	store, err := history.Open(filepath.Join(dir, ".redditdl", "history.db"))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	s := NewSaver(defaultArgs(dir, 10), 1, 1)
+	s.history = store
+	s.downloadPost(context.TODO(), dir, &stream.Result{Post: &post})
+	assert.Equal(t, int64(1), s.saved.Load())
+
+	entry, err := store.Get("t3_post")
+	assert.NoError(t, err)
+	assert.NotNil(t, entry)
+	assert.Equal(t, []string{filepath.Join("wallpaper", "image.png")}, entry.Paths)
+
+	s.downloadPost(context.TODO(), dir, &stream.Result{Post: &post})
+	s.downloadPost(context.TODO(), dir, &stream.Result{Post: &repost})
+	assert.Equal(t, int64(1), s.saved.Load())
+	assert.Equal(t, int64(2), s.skipped.Load(), "posts from the history should be skipped")
+	assert.Equal(t, int32(1), requests.Load(), "posts from the history should not be fetched")
+
+	assert.NoError(t, pruneHistory(store, &HistoryPruneCommand{Missing: true}, dir))
+	seen, err := store.Seen("t3_post", "")
+	assert.NoError(t, err)
+	assert.True(t, seen, "posts with existing files should be kept")
+	assert.NoError(t, os.Remove(filepath.Join(dir, "wallpaper", "image.png")))
+	assert.NoError(t, pruneHistory(store, &HistoryPruneCommand{Missing: true}, dir))
+	seen, err = store.Seen("t3_post", "")
+	assert.NoError(t, err)
+	assert.False(t, seen, "posts whose files are gone should be pruned")
+}
+
+func TestDownloadPostGalleryCount(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/gif")
+		_, err := w.Write([]byte(r.URL.Path))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	var post api.Post
+	assert.NoError(t, json.Unmarshal([]byte(fmt.Sprintf(`{
+		"name": "t3_g", "id": "g", "subreddit": "wallpaper", "is_gallery": true,
+		"gallery_data": {"items": [{"media_id": "a"}, {"media_id": "b"}, {"media_id": "c"}]},
+		"media_metadata": {
+			"a": {"status": "valid", "s": {"gif": "%[1]s/a.gif"}},
+			"b": {"status": "valid", "s": {"gif": "%[1]s/b.gif"}},
+			"c": {"status": "valid", "s": {"gif": "%[1]s/c.gif"}}
+		}}`, server.URL)), &post.Data))
+
+	dir := t.TempDir()
+	store, err := history.Open(filepath.Join(dir, ".redditdl", "history.db"))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	s := NewSaver(defaultArgs(dir, 2), 1, 1)
+	s.history = store
+	s.downloadPost(context.TODO(), dir, &stream.Result{Post: &post})
+	assert.Equal(t, int64(2), s.saved.Load())
+
+	entry, err := store.Get("t3_g")
+	assert.NoError(t, err)
+	if assert.NotNil(t, entry, "the saved items of an incomplete gallery should be recorded") {
+		assert.Len(t, entry.Paths, 2)
+	}
+}
+
+func TestRunInterrupted(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/r/wallpaper/best.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, err := fmt.Fprintf(w, `{"data": {"after": null, "children": [{"kind": "t3", "data": {
+			"name": "t3_a", "subreddit": "wallpaper", "post_hint": "image", "url": "http://%s/image.png"}}]}}`, r.Host)
+		assert.NoError(t, err)
+	})
+	mux.HandleFunc("/image.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Content-Length", "100")
+		_, err := w.Write([]byte("first"))
+		assert.NoError(t, err)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done() // The rest never comes.
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	s := NewSaver(defaultArgs(dir, 10), 1, 1)
+	s.client = s.client.WithBaseURL(u).WithRetryPolicy(api.RetryPolicy{MaxAttempts: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+
+	// Interrupt the download once a part of it is written.
+	path := filepath.Join(dir, "wallpaper", "image.png")
+	assert.Eventually(t, func() bool {
+		stat, err := os.Stat(path + ".part")
+		return err == nil && stat.Size() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("run was not stopped")
+	}
+
+	assert.False(t, FileExists(path), "interrupted download should not be saved")
+	assert.True(t, FileExists(path+".part"), "interrupted download should be kept to be resumed")
+	assert.True(t, FileExists(path+".part.json"))
+	assert.Equal(t, int64(0), s.failed.Load(), "interrupted download is not a failure")
+}
+
+func TestDispatchStopsFailingSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/r/wallpaper/best.json" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"data": {"after": null, "children": [{"kind": "t3", "data": {"name": "t3_a"}}]}}`))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+
+	args := defaultArgs(t.TempDir(), 10)
+	s := NewSaver(args, 1, 10)
+	s.client = s.client.WithBaseURL(u).WithRetryPolicy(api.RetryPolicy{MaxAttempts: 1})
+	opts := s.argsAsOpts([]string{"failing", "wallpaper"}, nil)
+	opts.RetryDelay = time.Millisecond
+	feed, err := stream.New(s.client, opts, 1)
+	assert.NoError(t, err)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.dispatch(context.Background(), feed, feed.Start(context.Background()))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("failing source was not stopped")
+	}
+
+	assert.Equal(t, maxSourceFailures, s.sourceFailures[stream.Source{Kind: stream.SourceSubreddit, Name: "failing"}])
+	assert.Len(t, s.downloadCh, 1, "posts of the other source should be downloaded")
+}
+
+func TestRunCountPerSource(t *testing.T) {
+	var requests sync.Map
+	mux := http.NewServeMux()
+	mux.HandleFunc("/r/", func(w http.ResponseWriter, r *http.Request) {
+		subreddit := strings.Split(r.URL.Path, "/")[2]
+		// The endless listing of the wallpapers subreddit has to be stopped by the quota.
+		after := `"t3_next"`
+		if subreddit == "wallpaper" {
+			after = "null"
+		}
+		n, _ := requests.LoadOrStore(subreddit, new(atomic.Int32))
+		page := n.(*atomic.Int32).Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, err := fmt.Fprintf(w, `{"data": {"after": %s, "children": [`, after)
+		assert.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			if i > 0 {
+				_, err = w.Write([]byte(","))
+				assert.NoError(t, err)
+			}
+			_, err = fmt.Fprintf(w, `{"kind": "t3", "data": {"name": "t3_%[1]s%[2]d_%[3]d", "title": "%[1]s %[2]d %[3]d",
+				"subreddit": "%[1]s", "post_hint": "image", "url": "http://%[4]s/image/%[1]s%[2]d_%[3]d.png"}}`, subreddit, page, i, r.Host)
+			assert.NoError(t, err)
+		}
+		_, err = w.Write([]byte("]}}"))
+		assert.NoError(t, err)
+	})
+	mux.HandleFunc("/image/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, err := w.Write([]byte(r.URL.Path))
+		assert.NoError(t, err)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	args := defaultArgs(dir, 0)
+	args.SubredditList = "wallpaper,wallpapers"
+	args.CountPerSource = 2
+	args.Schedule = stream.ScheduleRoundRobin
+	s := NewSaver(args, 2, 2)
+	s.client = s.client.WithBaseURL(u).WithRetryPolicy(api.RetryPolicy{MaxAttempts: 1})
+
+	done := make(chan error)
+	go func() { done <- s.Run(context.Background()) }()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("run was not finished once the quotas were met")
+	}
+
+	for _, subreddit := range []string{"wallpaper", "wallpapers"} {
+		entries, err := os.ReadDir(filepath.Join(dir, subreddit))
+		assert.NoError(t, err)
+		assert.Len(t, entries, 2, "subreddit %s should have its quota saved", subreddit)
+	}
+	assert.Equal(t, int64(4), s.saved.Load())
+}
+
+func TestWeights(t *testing.T) {
+	args := AppArguments{
+		SubredditList: "Wallpaper,earthporn,/user/artist/m/walls",
+		Users:         "artist",
+		Saved:         true,
+		Weights:       "wallpaper=3, /r/EarthPorn=2,user/Artist=4,u/artist/m/walls=5,me/saved=6",
+	}
+	weights, err := args.weights()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"r/Wallpaper": 3, "r/earthporn": 2, "u/artist": 4, "u/artist/m/walls": 5, "me/saved": 6}, weights,
+		"weights should be keyed by the sources they match")
+
+	for _, invalid := range []string{"wallpaper", "wallpaper=0", "wallpaper=x", "wallpapers=2", "u/other=2"} {
+		args.Weights = invalid
+		_, err := args.weights()
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestFolder(t *testing.T) {
+	var p api.Post
+	p.Data.Subreddit = "WallPaper"
+	assert.Equal(t, "wallpaper", folder(&stream.Result{Post: &p, Source: stream.Source{Kind: stream.SourceSubreddit, Name: "WallPaper"}}))
+	assert.Equal(t, "u_artist", folder(&stream.Result{Post: &p, Source: stream.Source{Kind: stream.SourceUser, Name: "Artist"}}),
+		"user submissions should be kept in the user folder")
+	assert.Equal(t, []string{"a", "b"}, splitList(" a, ,b,"))
+}
+
 func BenchmarkDownload10(b *testing.B) {
 	ctx := context.TODO()
 	for i := 0; i < b.N; i++ {
@@ -58,6 +486,8 @@ func defaultArgs(dir string, count int64) *AppArguments {
 		MediaOrientation:     "all",
 		MediaMinimalWidth:    0,
 		MediaMinimalHeight:   0,
+		Crossposts:           "keep",
+		Dedupe:               DedupeOff,
 		SaveDirectory:        dir,
 		VerboseLogging:       false,
 		ProgressLogging:      false,
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/handsomefox/redditdl/api"
+	"github.com/handsomefox/redditdl/imagemeta"
+	"github.com/rs/zerolog/log"
+)
+
+// Formats of the sidecar files.
+const (
+	SidecarJSON = "json"
+	SidecarText = "txt"
+	SidecarNFO  = "nfo"
+)
+
+// Metadata describes the saved media and its post.
+type Metadata struct {
+	XMLName     xml.Name  `json:"-" xml:"post"`
+	Name        string    `json:"name" xml:"name"`
+	ID          string    `json:"id" xml:"id"`
+	Title       string    `json:"title" xml:"title"`
+	Author      string    `json:"author" xml:"author"`
+	Subreddit   string    `json:"subreddit" xml:"subreddit"`
+	Permalink   string    `json:"permalink" xml:"permalink"`
+	Score       int       `json:"score" xml:"score"`
+	UpvoteRatio float64   `json:"upvote_ratio" xml:"upvote_ratio"`
+	NumComments int       `json:"num_comments" xml:"num_comments"`
+	Flair       string    `json:"flair,omitempty" xml:"flair,omitempty"`
+	Created     time.Time `json:"created" xml:"created"`
+	NSFW        bool      `json:"nsfw" xml:"nsfw"`
+	Spoiler     bool      `json:"spoiler" xml:"spoiler"`
+	Domain      string    `json:"domain,omitempty" xml:"domain,omitempty"`
+	// URL is the url the media was downloaded from.
+	URL    string `json:"url" xml:"url"`
+	Type   string `json:"type" xml:"type"`
+	Width  int    `json:"width,omitempty" xml:"width,omitempty"`
+	Height int    `json:"height,omitempty" xml:"height,omitempty"`
+	// Index is the position of the image in a gallery, starting from 1.
+	Index int `json:"index,omitempty" xml:"index,omitempty"`
+	// File is the name of the saved media file.
+	File string `json:"file" xml:"file"`
+}
+
+func newMetadata(post *api.Post, item *api.Item, path string) *Metadata {
+	return &Metadata{
+		Name:        post.Name(),
+		ID:          post.ID(),
+		Title:       post.Title(),
+		Author:      post.Author(),
+		Subreddit:   post.Subreddit(),
+		Permalink:   post.Permalink(),
+		Score:       post.Score(),
+		UpvoteRatio: post.UpvoteRatio(),
+		NumComments: post.NumComments(),
+		Flair:       post.Flair(),
+		Created:     post.Created(),
+		NSFW:        post.IsNSFW(),
+		Spoiler:     post.IsSpoiler(),
+		Domain:      post.Domain(),
+		URL:         item.URL,
+		Type:        item.Type,
+		Width:       item.Width,
+		Height:      item.Height,
+		Index:       item.Index,
+		File:        filepath.Base(path),
+	}
+}
+
+// fields returns the metadata embedded into the images.
+func (m *Metadata) fields() imagemeta.Fields {
+	return imagemeta.Fields{Title: m.Title, Author: m.Author, Subreddit: m.Subreddit, Permalink: m.Permalink, Created: m.Created}
+}
+
+// writeSidecars writes the metadata of the media saved at the path next to it, a file per requested format,
+// e.g. image.jpg.json. Failures are only logged, the media is saved anyway.
+func (s *Saver) writeSidecars(path string, post *api.Post, item *api.Item) {
+	formats := s.args.sidecarFormats()
+	if len(formats) == 0 {
+		return
+	}
+	m := newMetadata(post, item, path)
+	for _, format := range formats {
+		b, err := m.encode(format)
+		if err == nil {
+			err = writeFileAtomic(path+"."+format, b)
+		}
+		if err != nil {
+			log.Err(err).Str("path", path).Str("format", format).Msg("failed to write the sidecar file")
+		}
+	}
+}
+
+// removeSidecars removes the sidecar files of the media, e.g. when it was replaced with a better copy.
+func (s *Saver) removeSidecars(path string) {
+	for _, format := range s.args.sidecarFormats() {
+		if err := os.Remove(path + "." + format); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Err(err).Str("path", path).Str("format", format).Msg("failed to remove the sidecar file")
+		}
+	}
+}
+
+// encode returns the sidecar file content in the format.
+func (m *Metadata) encode(format string) ([]byte, error) {
+	switch format {
+	case SidecarJSON:
+		b, err := json.MarshalIndent(m, "", "  ")
+		return append(b, '\n'), err
+	case SidecarNFO:
+		b, err := xml.MarshalIndent(m, "", "  ")
+		return append([]byte(xml.Header), append(b, '\n')...), err
+	case SidecarText:
+		var buf bytes.Buffer
+		fields := []struct {
+			key   string
+			value any
+		}{
+			{"Title", m.Title},
+			{"Author", m.Author},
+			{"Subreddit", m.Subreddit},
+			{"Permalink", m.Permalink},
+			{"Score", m.Score},
+			{"Upvote ratio", m.UpvoteRatio},
+			{"Comments", m.NumComments},
+			{"Flair", m.Flair},
+			{"Created", m.Created.Format(time.RFC3339)},
+			{"NSFW", m.NSFW},
+			{"URL", m.URL},
+			{"Type", m.Type},
+			{"Resolution", fmt.Sprintf("%dx%d", m.Width, m.Height)},
+			{"File", m.File},
+		}
+		for _, f := range fields {
+			fmt.Fprintf(&buf, "%s: %v\n", f.key, f.value)
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown sidecar format: %s", format)
+	}
+}
+
+// sidecarFormats returns the formats of the requested sidecar files.
+func (args *AppArguments) sidecarFormats() []string {
+	return splitList(args.Sidecar)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/handsomefox/redditdl/api"
+	"github.com/handsomefox/redditdl/history"
+	"github.com/handsomefox/redditdl/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSidecars(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, err := w.Write([]byte("image"))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	var p api.Post
+	p.Data.Name = "t3_11tug3p"
+	p.Data.ID = "11tug3p"
+	p.Data.Title = "Staring into the woods"
+	p.Data.Author = "The_Romero"
+	p.Data.Subreddit = "wallpaper"
+	p.Data.Permalink = "/r/wallpaper/comments/11tug3p/staring_into_the_woods/"
+	p.Data.Score = 42
+	p.Data.CreatedUTC = 1679043600
+	p.Data.PostHint = "image"
+	p.Data.URL = server.URL + "/image.png"
+
+	dir := t.TempDir()
+	args := defaultArgs(dir, 10)
+	args.Sidecar = "json,txt,nfo"
+	s := NewSaver(args, 1, 1)
+	s.downloadPost(context.TODO(), dir, &stream.Result{Post: &p})
+	assert.Equal(t, int64(1), s.saved.Load())
+
+	path := filepath.Join(dir, "wallpaper", "image.png")
+	b, err := os.ReadFile(path + ".json")
+	assert.NoError(t, err)
+	var m Metadata
+	assert.NoError(t, json.Unmarshal(b, &m))
+	assert.Equal(t, "Staring into the woods", m.Title)
+	assert.Equal(t, "The_Romero", m.Author)
+	assert.Equal(t, "https://www.reddit.com/r/wallpaper/comments/11tug3p/staring_into_the_woods/", m.Permalink)
+	assert.Equal(t, server.URL+"/image.png", m.URL)
+	assert.Equal(t, "image.png", m.File)
+	assert.Equal(t, int64(1679043600), m.Created.Unix())
+
+	b, err = os.ReadFile(path + ".nfo")
+	assert.NoError(t, err)
+	var nfo Metadata
+	assert.NoError(t, xml.Unmarshal(b, &nfo))
+	assert.Equal(t, m.Permalink, nfo.Permalink)
+
+	b, err = os.ReadFile(path + ".txt")
+	assert.NoError(t, err)
+	assert.True(t, strings.Contains(string(b), "Subreddit: wallpaper\n"))
+}
+
+func TestFinalizeEmbedsMetadata(t *testing.T) {
+	var img bytes.Buffer
+	assert.NoError(t, png.Encode(&img, image.NewGray(image.Rect(0, 0, 4, 4))))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, err := w.Write(img.Bytes())
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	var p api.Post
+	p.Data.Name = "t3_11tug3p"
+	p.Data.Title = "Staring into the woods"
+	p.Data.Author = "The_Romero"
+	p.Data.Subreddit = "wallpaper"
+	p.Data.PostHint = "image"
+	p.Data.URL = server.URL + "/image.png"
+
+	dir := t.TempDir()
+	args := defaultArgs(dir, 10)
+	args.EmbedMetadata = true
+	s := NewSaver(args, 1, 1)
+	s.downloadPost(context.TODO(), dir, &stream.Result{Post: &p})
+	assert.Equal(t, int64(1), s.saved.Load())
+
+	b, err := os.ReadFile(filepath.Join(dir, "wallpaper", "image.png"))
+	assert.NoError(t, err)
+	assert.True(t, bytes.Contains(b, []byte("tEXtAuthor\x00The_Romero")))
+	_, err = png.Decode(bytes.NewReader(b))
+	assert.NoError(t, err)
+
+	// The linked files are shared by the posts, so they carry no post's metadata.
+	dir = t.TempDir()
+	store, err := history.Open(filepath.Join(dir, ".redditdl", "history.db"))
+	assert.NoError(t, err)
+	defer store.Close()
+	args = defaultArgs(dir, 10)
+	args.EmbedMetadata = true
+	args.Dedupe = DedupeHardlink
+	s = NewSaver(args, 1, 1)
+	s.history = store
+	s.downloadPost(context.TODO(), dir, &stream.Result{Post: &p})
+	b, err = os.ReadFile(filepath.Join(dir, "wallpaper", "image.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, img.Bytes(), b, "metadata should not be embedded into the deduplicated files")
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/handsomefox/redditdl/history"
+	"github.com/handsomefox/redditdl/imagehash"
+	"github.com/rs/zerolog/log"
+)
+
+// ReportCommand lists the near-duplicate images in the output directory.
+type ReportCommand struct{}
+
+// errBetterCopy stops the lookup of the similar images, once a better copy of the image was found.
+var errBetterCopy = errors.New("image has a better copy")
+
+// hashableExtensions are the extensions of the images, that can be decoded to compute their perceptual hash.
+var hashableExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+func isHashable(path string) bool {
+	return hashableExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// keepBestCopy compares the saved image at the path with the images saved before.
+// Of the similar images, only the one with the highest resolution is kept: if there is a better copy,
+// the new image is removed and the path of the copy (relative to the working directory) is returned,
+// otherwise the worse copies are removed, and the history entries, the file hashes and the symlinks
+// that referred to them are pointed at the new image.
+func (s *Saver) keepBestCopy(wd, path string) (string, bool) {
+	if !s.args.Similar || s.history == nil || !isHashable(path) {
+		return "", false
+	}
+
+	hash, err := imagehash.File(path)
+	if err != nil {
+		log.Debug().Err(err).Str("path", path).Msg("failed to compute the perceptual hash")
+		return "", false
+	}
+	rel, err := filepath.Rel(wd, path)
+	if err != nil {
+		log.Err(err).Str("path", path).Msg("failed to resolve the path of the file")
+		return "", false
+	}
+
+	s.dedupeMu.Lock()
+	defer s.dedupeMu.Unlock()
+
+	var better string
+	var worse []string
+	err = s.history.Images(func(other string, image history.Image) error {
+		if other == rel || imagehash.Distance(hash.Value, image.Hash) > s.args.SimilarDistance {
+			return nil
+		}
+		if !FileExists(filepath.Join(wd, other)) {
+			return nil
+		}
+		if image.Width*image.Height >= hash.Pixels() {
+			better = other
+			return errBetterCopy
+		}
+		worse = append(worse, other)
+		return nil
+	})
+	if errors.Is(err, errBetterCopy) {
+		if err := os.Remove(path); err != nil {
+			log.Err(err).Str("path", path).Msg("failed to remove the near-duplicate image")
+			return "", false
+		}
+		log.Debug().Str("path", path).Str("original", better).Msg("removed a near-duplicate of a better image")
+		return better, true
+	}
+	if err != nil {
+		log.Err(err).Msg("failed to look up the similar images")
+		return "", false
+	}
+
+	for _, other := range worse {
+		if err := os.Remove(filepath.Join(wd, other)); err != nil {
+			log.Err(err).Str("path", other).Msg("failed to remove the near-duplicate image")
+			continue
+		}
+		s.removeSidecars(filepath.Join(wd, other))
+		if err := s.history.RemoveImage(other); err != nil {
+			log.Err(err).Str("path", other).Msg("failed to remove the perceptual hash")
+		}
+		// Otherwise, pruning the history would drop the posts of the removed copy, and they would be downloaded again.
+		if err := s.history.ReplacePath(other, rel); err != nil {
+			log.Err(err).Str("path", other).Msg("failed to replace the path in the history")
+		}
+		repointSymlinks(wd, other, rel)
+		log.Debug().Str("path", other).Str("replacement", rel).Msg("replaced a near-duplicate with a better image")
+	}
+	image := history.Image{Hash: hash.Value, Width: hash.Width, Height: hash.Height}
+	if err := s.history.AddImage(rel, image); err != nil {
+		log.Err(err).Str("path", path).Msg("failed to add the perceptual hash")
+	}
+	return "", false
+}
+
+// repointSymlinks replaces the symlinks to the removed file (e.g. made by --dedupe symlink) in the working directory
+// with the symlinks to its replacement, both paths are relative to it.
+func repointSymlinks(wd, removed, replacement string) {
+	removed = filepath.Join(wd, removed)
+	err := filepath.WalkDir(wd, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".redditdl" {
+			return filepath.SkipDir
+		}
+		if d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			log.Debug().Err(err).Str("path", path).Msg("failed to read the symlink")
+			return nil
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		if filepath.Clean(target) != removed {
+			return nil
+		}
+		if err := replaceDuplicate(DedupeSymlink, filepath.Join(wd, replacement), path); err != nil {
+			log.Err(err).Str("path", path).Str("replacement", replacement).Msg("failed to repoint the symlink")
+		}
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Str("path", removed).Msg("failed to look up the symlinks to the removed image")
+	}
+}
+
+// runReport writes the clusters of the near-duplicate images in the output directory to w,
+// each cluster starts with the image of the highest resolution.
+func runReport(args *AppArguments, w io.Writer) error {
+	hashes := make(map[string]imagehash.Hash)
+	err := filepath.WalkDir(args.SaveDirectory, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".redditdl" {
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() || !isHashable(path) {
+			return nil
+		}
+		hash, err := imagehash.File(path)
+		if err != nil {
+			log.Debug().Err(err).Str("path", path).Msg("skipped an image")
+			return nil
+		}
+		hashes[path] = hash
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	clusters := imagehash.Clusters(hashes, args.SimilarDistance)
+	for i, cluster := range clusters {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		for _, path := range cluster {
+			if _, err := fmt.Fprintf(w, "%dx%d\t%s\n", hashes[path].Width, hashes[path].Height, path); err != nil {
+				return err
+			}
+		}
+	}
+	log.Info().Int("images", len(hashes)).Int("clusters", len(clusters)).Msg("finished the report")
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	imagecolor "image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/handsomefox/redditdl/api"
+	"github.com/handsomefox/redditdl/history"
+	"github.com/handsomefox/redditdl/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+// testJPEG encodes a gradient image of the size, the quality makes the copies differ byte-wise.
+func testJPEG(t *testing.T, w, h, quality int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8((x*255/w + y*255/h) / 2)
+			if (y*8/h)%2 == 0 {
+				v = 255 - v
+			}
+			img.Set(x, y, imagecolor.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}))
+	return buf.Bytes()
+}
+
+func TestKeepBestCopy(t *testing.T) {
+	files := map[string][]byte{
+		"/medium.jpg": testJPEG(t, 400, 300, 80),
+		"/large.jpg":  testJPEG(t, 800, 600, 90),
+		"/small.jpg":  testJPEG(t, 200, 150, 50),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, err := w.Write(files[r.URL.Path])
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	store, err := history.Open(filepath.Join(dir, ".redditdl", "history.db"))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	args := defaultArgs(dir, 10)
+	args.Similar = true
+	args.SimilarDistance = 5
+	s := NewSaver(args, 1, 1)
+	s.history = store
+
+	for _, name := range []string{"medium", "large", "small"} {
+		var p api.Post
+		p.Data.Name = "t3_" + name
+		p.Data.Subreddit = "wallpaper"
+		p.Data.PostHint = "image"
+		p.Data.URL = server.URL + "/" + name + ".jpg"
+		s.downloadPost(context.TODO(), dir, &stream.Result{Post: &p})
+	}
+
+	assert.Equal(t, int64(2), s.saved.Load())
+	assert.Equal(t, int64(1), s.skipped.Load(), "smaller copy should be skipped")
+	assert.False(t, FileExists(filepath.Join(dir, "wallpaper", "medium.jpg")), "smaller copy should be replaced")
+	assert.True(t, FileExists(filepath.Join(dir, "wallpaper", "large.jpg")))
+	assert.False(t, FileExists(filepath.Join(dir, "wallpaper", "small.jpg")))
+
+	// The report finds the similar images in the directory, whether they were downloaded or not.
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "wallpaper", "copy.jpg"), files["/small.jpg"], 0o666))
+	var out bytes.Buffer
+	assert.NoError(t, runReport(args, &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 2, len(lines))
+	assert.True(t, strings.HasPrefix(lines[0], "800x600\t"), "best copy should be listed first")
+	assert.True(t, strings.HasSuffix(lines[1], "copy.jpg"))
+}
+
+func TestKeepBestCopySymlinks(t *testing.T) {
+	medium := testJPEG(t, 400, 300, 80)
+	files := map[string][]byte{
+		"/medium.jpg": medium,
+		"/repost.jpg": medium,
+		"/large.jpg":  testJPEG(t, 800, 600, 90),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, err := w.Write(files[r.URL.Path])
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	store, err := history.Open(filepath.Join(dir, ".redditdl", "history.db"))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	args := defaultArgs(dir, 10)
+	args.Similar = true
+	args.SimilarDistance = 5
+	args.Dedupe = DedupeSymlink
+	s := NewSaver(args, 1, 1)
+	s.history = store
+
+	// The repost of the medium copy is linked to it, then the large copy replaces both.
+	for _, name := range []string{"medium", "repost", "large"} {
+		var p api.Post
+		p.Data.Name = "t3_" + name
+		p.Data.Subreddit = "wallpaper"
+		p.Data.PostHint = "image"
+		p.Data.URL = server.URL + "/" + name + ".jpg"
+		s.downloadPost(context.TODO(), dir, &stream.Result{Post: &p})
+	}
+
+	large := filepath.Join("wallpaper", "large.jpg")
+	assert.False(t, FileExists(filepath.Join(dir, "wallpaper", "medium.jpg")), "smaller copy should be replaced")
+	b, err := os.ReadFile(filepath.Join(dir, "wallpaper", "repost.jpg"))
+	assert.NoError(t, err, "symlink to the replaced copy should not dangle")
+	assert.Equal(t, files["/large.jpg"], b)
+
+	// The repost keeps its own path, it's the symlink.
+	for name, want := range map[string]string{"t3_medium": large, "t3_repost": filepath.Join("wallpaper", "repost.jpg"), "t3_large": large} {
+		entry, err := store.Get(name)
+		assert.NoError(t, err)
+		if assert.NotNil(t, entry, name) {
+			assert.Equal(t, []string{want}, entry.Paths, "history of %s should refer to an existing file", name)
+		}
+	}
+	hash := sha256.Sum256(medium)
+	path, err := store.File(hex.EncodeToString(hash[:]))
+	assert.NoError(t, err)
+	assert.Equal(t, large, path, "hash of the replaced copy should refer to the kept copy")
+}
@@ -0,0 +1,67 @@
+package stream
+
+import "context"
+
+// Schedules, the orders the results of multiple sources are yielded in.
+const (
+	// ScheduleAny yields the results as soon as they are fetched, so the faster sources yield more of them.
+	ScheduleAny = "any"
+	// ScheduleRoundRobin yields a result from each source in turn.
+	ScheduleRoundRobin = "round-robin"
+	// ScheduleWeighted yields as many results from each source in turn, as its weight is.
+	ScheduleWeighted = "weighted"
+)
+
+// weight returns the number of results yielded from the source in its turn.
+func (o *Options) weight(source Source) int {
+	if o.Schedule != ScheduleWeighted {
+		return 1
+	}
+	if weight, ok := o.Weights[source.String()]; ok && weight > 0 {
+		return weight
+	}
+	return 1
+}
+
+// schedule sends the results of the workers to the output channel in turns, the inputs are the channels of the workers.
+// A source that is being fetched delays the others, so that every source gets its share.
+// It returns once all the inputs are closed, or the context is cancelled.
+func (s *Stream) schedule(ctx context.Context, inputs []<-chan *Result, out chan<- *Result) {
+	weights := make([]int, len(inputs))
+	for i := range inputs {
+		weights[i] = s.opts.weight(s.workers[i].source)
+	}
+
+	for len(inputs) > 0 {
+		for i := 0; i < len(inputs); {
+			closed := false
+			for n := 0; n < weights[i]; n++ {
+				var (
+					res *Result
+					ok  bool
+				)
+				select {
+				case res, ok = <-inputs[i]:
+				case <-ctx.Done():
+					return
+				}
+				if !ok {
+					closed = true
+					break
+				}
+				select {
+				case out <- res:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if closed {
+				// The source is exhausted or stopped.
+				inputs = append(inputs[:i], inputs[i+1:]...)
+				weights = append(weights[:i], weights[i+1:]...)
+				continue
+			}
+			i++
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package stream
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/handsomefox/redditdl/api"
+)
+
+// SourceKind is the kind of listing the posts are fetched from.
+type SourceKind string
+
+const (
+	// SourceSubreddit is the listing of a subreddit, e.g. /r/wallpaper/top.
+	SourceSubreddit SourceKind = "subreddit"
+	// SourceUser is the listing of the submissions of a user, e.g. /user/spez/submitted.
+	SourceUser SourceKind = "user"
+	// SourceSearch is the listing of search results, the name is the query.
+	SourceSearch SourceKind = "search"
+	// SourceMultireddit is the listing of a multireddit (custom feed), the name is "{user}/{multireddit}".
+	SourceMultireddit SourceKind = "multireddit"
+	// SourceSaved and SourceUpvoted are the listings of the authenticated user,
+	// the name is the username, it's requested from reddit if it's empty.
+	SourceSaved   SourceKind = "saved"
+	SourceUpvoted SourceKind = "upvoted"
+)
+
+// ParseSubreddit parses an entry of the subreddit list.
+// Besides the subreddit names ("wallpaper" or "r/wallpaper"), it accepts combined subreddits ("a+b+c"),
+// which are fetched as a single source, and multireddit paths ("/user/{user}/m/{multireddit}").
+func ParseSubreddit(entry string) Source {
+	path := strings.Trim(strings.TrimSpace(entry), "/")
+	parts := strings.Split(path, "/")
+	if len(parts) == 4 && (parts[0] == "user" || parts[0] == "u") && parts[2] == "m" {
+		return Source{Kind: SourceMultireddit, Name: parts[1] + "/" + parts[3]}
+	}
+	if len(parts) == 2 && parts[0] == "r" {
+		path = parts[1]
+	}
+	return Source{Kind: SourceSubreddit, Name: path}
+}
+
+// Source is a single listing the stream fetches the posts from.
+type Source struct {
+	Kind SourceKind
+	Name string
+	// Subreddit restricts the search to a single subreddit, it's only used by search sources.
+	Subreddit string
+}
+
+func (s Source) String() string {
+	switch s.Kind {
+	case SourceUser:
+		return "u/" + s.Name
+	case SourceMultireddit:
+		user, name, _ := strings.Cut(s.Name, "/")
+		return "u/" + user + "/m/" + name
+	case SourceSaved, SourceUpvoted:
+		if s.Name == "" {
+			return "me/" + string(s.Kind)
+		}
+		return "u/" + s.Name + "/" + string(s.Kind)
+	case SourceSearch:
+		if s.Subreddit != "" {
+			return fmt.Sprintf("search %q in r/%s", s.Name, s.Subreddit)
+		}
+		return fmt.Sprintf("search %q", s.Name)
+	default:
+		return "r/" + s.Name
+	}
+}
+
+// requestOptions returns the options to fetch a page of the source listing.
+func (s Source) requestOptions(opts *Options, after string) *api.RequestOptions {
+	req := &api.RequestOptions{
+		After:     after,
+		Count:     100,
+		Sorting:   opts.Sort,
+		Timeframe: opts.Timeframe,
+	}
+	switch s.Kind {
+	case SourceUser:
+		req.User = s.Name
+	case SourceMultireddit:
+		req.Multireddit = s.Name
+	case SourceSaved, SourceUpvoted:
+		req.User, req.UserListing = s.Name, string(s.Kind)
+		if opts.ItemKind != "all" {
+			req.Kind = opts.ItemKind
+		}
+	case SourceSearch:
+		req.Query, req.Subreddit, req.SearchType = s.Name, s.Subreddit, opts.SearchType
+		if opts.SearchSort != "" {
+			req.Sorting = opts.SearchSort
+		}
+	default:
+		req.Subreddit = s.Name
+	}
+	return req
+}
+
+// isAccount reports whether the source is a listing of the authenticated user.
+func (s Source) isAccount() bool {
+	return s.Kind == SourceSaved || s.Kind == SourceUpvoted
+}
+
+// Result is a post yielded by the stream, or the error of fetching it, along with the source it was fetched from.
+type Result struct {
+	// Post is nil if the fetch failed.
+	Post   *api.Post
+	Source Source
+	// Cursor is the cursor of the listing page the post is on, or the page that failed to be fetched.
+	// It's empty for the first page.
+	Cursor string
+	Err    error
+}
@@ -0,0 +1,51 @@
+package stream
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSubreddit(t *testing.T) {
+	t.Parallel()
+	tests := map[string]Source{
+		"wallpaper":                 {Kind: SourceSubreddit, Name: "wallpaper"},
+		" /r/wallpaper/ ":           {Kind: SourceSubreddit, Name: "wallpaper"},
+		"wallpaper+wallpapers":      {Kind: SourceSubreddit, Name: "wallpaper+wallpapers"},
+		"/user/example/m/walls":     {Kind: SourceMultireddit, Name: "example/walls"},
+		"u/example/m/walls/":        {Kind: SourceMultireddit, Name: "example/walls"},
+		"/user/example/submitted/x": {Kind: SourceSubreddit, Name: "user/example/submitted/x"},
+	}
+	for entry, want := range tests {
+		assert.Equal(t, want, ParseSubreddit(entry), "entry=%q", entry)
+	}
+
+	assert.Equal(t, "u/example/m/walls", ParseSubreddit("/user/example/m/walls").String())
+}
+
+func TestSources(t *testing.T) {
+	t.Parallel()
+	opts := Options{
+		Subreddits:      []string{"wallpaper", "a+b"},
+		Users:           []string{"example"},
+		Search:          "lake",
+		SearchSubreddit: "wallpaper",
+		SearchSort:      "new",
+		SearchType:      "link,sr",
+		Sort:            "top",
+	}
+	sources := opts.Sources()
+	assert.Equal(t, []Source{
+		{Kind: SourceSubreddit, Name: "wallpaper"},
+		{Kind: SourceSubreddit, Name: "a+b"},
+		{Kind: SourceUser, Name: "example"},
+		{Kind: SourceSearch, Name: "lake", Subreddit: "wallpaper"},
+	}, sources)
+
+	req := sources[3].requestOptions(&opts, "t3_abc")
+	assert.Equal(t, "lake", req.Query)
+	assert.Equal(t, "wallpaper", req.Subreddit)
+	assert.Equal(t, "new", req.Sorting, "search should use its own sorting")
+	assert.Equal(t, "link,sr", req.SearchType)
+	assert.Equal(t, "t3_abc", req.After)
+}
@@ -1,8 +1,10 @@
 package stream
 
 import (
+	"context"
 	"fmt"
-	"sync/atomic"
+	"sync"
+	"time"
 
 	"github.com/handsomefox/redditdl/api"
 )
@@ -11,48 +13,84 @@ type Options struct {
 	ContentType string
 	Sort        string
 	Timeframe   string
-	Subreddits  []string
-	ShowNSFW    bool
+	// Subreddits are parsed using ParseSubreddit, so they may contain combined subreddits and multireddits.
+	Subreddits []string
+	// Users are the users whose submissions are fetched.
+	Users []string
+	// Search is the query to search the posts with, SearchSubreddit restricts the search to a subreddit.
+	Search          string
+	SearchSubreddit string
+	// SearchSort is the sorting of the search results, Sort is used if it's empty.
+	SearchSort string
+	// SearchType is the type of the search results, only the posts are searched if it's empty.
+	SearchType string
+	// Saved and Upvoted add the listings of the authenticated user.
+	Saved   bool
+	Upvoted bool
+	// ItemKind filters the items of the listings: "links", "comments" or "all".
+	// Only the saved and upvoted listings contain comments, so "all" is used if it's empty.
+	ItemKind string
+	ShowNSFW bool
+	// RetryDelay is the delay before refetching a page that failed to be fetched, 5 seconds if it's zero.
+	RetryDelay time.Duration
+	// Schedule is the order the results of the sources are yielded in, ScheduleAny if it's empty.
+	Schedule string
+	// Weights are the numbers of results yielded from each source in turn by ScheduleWeighted,
+	// keyed by the source string (e.g. "r/wallpaper", "u/artist"). The sources without a weight have 1.
+	Weights map[string]int
 }
 
-type Stream struct {
-	client *api.Client
-
-	consumerCh chan *api.Post
-	continueCh chan struct{}
-
-	workers []Worker
+// Sources returns the listings to fetch the posts from.
+func (o *Options) Sources() []Source {
+	sources := make([]Source, 0, len(o.Subreddits)+len(o.Users))
+	for _, name := range o.Subreddits {
+		sources = append(sources, ParseSubreddit(name))
+	}
+	for _, name := range o.Users {
+		sources = append(sources, Source{Kind: SourceUser, Name: name})
+	}
+	if o.Saved {
+		sources = append(sources, Source{Kind: SourceSaved})
+	}
+	if o.Upvoted {
+		sources = append(sources, Source{Kind: SourceUpvoted})
+	}
+	if o.Search != "" {
+		sources = append(sources, Source{Kind: SourceSearch, Name: o.Search, Subreddit: o.SearchSubreddit})
+	}
+	return sources
+}
 
-	workersDone atomic.Int32
-	completed   atomic.Bool
+// Stream fetches the posts of the sources concurrently, a worker per source.
+type Stream struct {
+	client     *api.Client
+	workers    []Worker
+	bufferSize int
 
-	terminates []chan struct{}
+	// mu guards the stop functions of the workers, which are set by Start.
+	mu sync.Mutex
 
 	opts Options
 }
 
 func New(client *api.Client, options Options, bufferSize int) (*Stream, error) {
-	if len(options.Subreddits) == 0 {
-		return nil, fmt.Errorf("empty subreddits provided")
+	sources := options.Sources()
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no sources provided")
 	}
 
 	s := &Stream{
-		client:      client,
-		consumerCh:  make(chan *api.Post, bufferSize),
-		continueCh:  make(chan struct{}, bufferSize),
-		workers:     make([]Worker, 0, len(options.Subreddits)),
-		workersDone: atomic.Int32{},
-		completed:   atomic.Bool{},
-		terminates:  nil,
-		opts:        options,
+		client:     client,
+		workers:    make([]Worker, 0, len(sources)),
+		bufferSize: bufferSize,
+		opts:       options,
 	}
 
-	for i := 0; i < len(s.opts.Subreddits); i++ {
+	for i := 0; i < len(sources); i++ {
 		s.workers = append(s.workers, Worker{
 			client:       s.client,
 			opts:         &s.opts,
-			outCh:        s.consumerCh,
-			subreddit:    s.opts.Subreddits[i],
+			source:       sources[i],
 			currentItems: nil,
 		})
 	}
@@ -60,48 +98,59 @@ func New(client *api.Client, options Options, bufferSize int) (*Stream, error) {
 	return s, nil
 }
 
-// Start returns the output channel.
-// The value in the output channel may be nil, if the fetch failed.
-func (s *Stream) Start() (<-chan *api.Post, error) {
-	go s.spinupWorkers()
-	return s.consumerCh, nil
-}
-
-// End reports to the stream that it has to end.
-func (s *Stream) Close() {
-	for i := 0; i < len(s.terminates); i++ {
-		s.terminates[i] <- struct{}{}
+// Start starts the workers and returns the channel the results are sent to.
+// The workers fetch the next pages as the results are received, up to the buffer size ahead.
+//
+// The channel is closed once all the sources are exhausted or the context is cancelled,
+// and all the workers have returned by then. To stop the stream early, cancel the context.
+// Start must only be called once.
+func (s *Stream) Start(ctx context.Context) <-chan *Result {
+	out := make(chan *Result, s.bufferSize)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The workers either share the output channel, or the scheduler takes the results from their own channels in turn.
+	scheduled := s.opts.Schedule != "" && s.opts.Schedule != ScheduleAny
+	inputs := make([]<-chan *Result, 0, len(s.workers))
+	var wg sync.WaitGroup
+	for i := range s.workers {
+		var workerCtx context.Context
+		workerCtx, s.workers[i].stop = context.WithCancel(ctx)
+		workerOut := out
+		if scheduled {
+			workerOut = make(chan *Result)
+			inputs = append(inputs, workerOut)
+		}
+		wg.Add(1)
+		go func(ctx context.Context, w *Worker, out chan *Result) {
+			defer wg.Done()
+			if scheduled {
+				defer close(out)
+			}
+			w.Run(ctx, out)
+		}(workerCtx, &s.workers[i], workerOut)
 	}
-	s.completed.Store(true)
-	close(s.continueCh)
-	close(s.consumerCh)
+	go func() {
+		if scheduled {
+			s.schedule(ctx, inputs, out)
+		}
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
 }
 
-// Continue reports to the stream that it has to fetch an item again.
-// Returns whether the Stream was completely finished.
-func (s *Stream) Continue() bool {
-	s.continueCh <- struct{}{}
-	return s.Done()
-}
-
-// Done return whether the Stream was completely finished.
-func (s *Stream) Done() bool {
-	if s.workersDone.Load() >= int32(len(s.opts.Subreddits)) {
-		s.completed.Store(true)
-	}
-	return s.completed.Load()
-}
+// Stop stops fetching the posts of the source, the other sources are not affected.
+// The results fetched before may still be received.
+func (s *Stream) Stop(source Source) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-func (s *Stream) spinupWorkers() {
-	for i := 0; i < len(s.workers); i++ {
-		i := i
-		terminate := make(chan struct{})
-		s.terminates = append(s.terminates, terminate)
-		// This improves performance if there's multiple subreddits
-		s.workers[i].tryPerformInitialFetch()
-		go func() {
-			_ = s.workers[i].Run(s.continueCh, terminate)
-			s.workersDone.Add(1)
-		}()
+	for i := range s.workers {
+		if s.workers[i].source == source && s.workers[i].stop != nil {
+			s.workers[i].stop()
+		}
 	}
 }
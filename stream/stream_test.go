@@ -0,0 +1,160 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/handsomefox/redditdl/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// checkGoroutines fails the test if the goroutines started after it was called are still running once the test ends.
+// Tests using it can not be parallel.
+func checkGoroutines(t *testing.T) {
+	t.Helper()
+	before := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		// The goroutines may take a moment to return, e.g. the connections of the closed server.
+		for deadline := time.Now().Add(5 * time.Second); runtime.NumGoroutine() > before; {
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<20)
+				t.Errorf("goroutines leaked, %d before:\n%s", before, buf[:runtime.Stack(buf, true)])
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+// listingServer serves the subreddit listings, pages pages of 2 posts each, or endless listings if pages is 0.
+// The listing of the "failing" subreddit always fails.
+func listingServer(t *testing.T, pages int) *api.Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		subreddit := strings.Split(r.URL.Path, "/")[2]
+		if subreddit == "failing" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		page := 0
+		fmt.Sscanf(r.URL.Query().Get("after"), "t3_"+subreddit+"_%d", &page) //nolint:errcheck // The first page has no cursor.
+		after := "null"
+		if pages == 0 || page/2+1 < pages {
+			after = fmt.Sprintf(`"t3_%s_%d"`, subreddit, page+2)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, err := fmt.Fprintf(w, `{"data": {"after": %s, "children": [{"kind": "t3", "data": {"name": "t3_%[2]s_%[3]d"}}, {"kind": "t3", "data": {"name": "t3_%[2]s_%[4]d"}}]}}`,
+			after, subreddit, page+1, page+2)
+		assert.NoError(t, err)
+	}))
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	return api.DefaultClient().WithBaseURL(u).WithRetryPolicy(api.RetryPolicy{MaxAttempts: 1})
+}
+
+func TestStreamExhausted(t *testing.T) {
+	checkGoroutines(t)
+	s, err := New(listingServer(t, 2), Options{Subreddits: []string{"a", "b"}}, 1)
+	assert.NoError(t, err)
+
+	var names []string
+	for res := range s.Start(context.Background()) {
+		names = append(names, res.Post.Data.Name)
+	}
+	sort.Strings(names)
+	assert.Equal(t, []string{
+		"t3_a_1", "t3_a_2", "t3_a_3", "t3_a_4",
+		"t3_b_1", "t3_b_2", "t3_b_3", "t3_b_4",
+	}, names, "the channel should be closed once all the sources are exhausted")
+}
+
+func TestStreamCancel(t *testing.T) {
+	checkGoroutines(t)
+	s, err := New(listingServer(t, 0), Options{Subreddits: []string{"a", "b", "c"}}, 4)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	results := s.Start(ctx)
+	for i := 0; i < 10; i++ {
+		assert.NotNil(t, (<-results).Post)
+	}
+	cancel()
+
+	// The buffered results may still be received, but the channel has to be closed.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for range results {
+		}
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the channel should be closed once the context is cancelled")
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	checkGoroutines(t)
+	s, err := New(listingServer(t, 2), Options{Subreddits: []string{"failing", "a"}, RetryDelay: time.Millisecond}, 1)
+	assert.NoError(t, err)
+
+	failing := Source{Kind: SourceSubreddit, Name: "failing"}
+	var (
+		errors  int
+		cursors = map[string]string{}
+	)
+	for res := range s.Start(context.Background()) {
+		if res.Err == nil {
+			cursors[res.Post.Data.Name] = res.Cursor
+			continue
+		}
+		assert.Equal(t, failing, res.Source)
+		assert.Nil(t, res.Post)
+		if errors++; errors == 3 {
+			s.Stop(res.Source)
+		}
+	}
+	assert.GreaterOrEqual(t, errors, 3, "the failing page should be refetched until the source is stopped")
+	assert.Equal(t, map[string]string{"t3_a_1": "", "t3_a_2": "", "t3_a_3": "t3_a_2", "t3_a_4": "t3_a_2"}, cursors,
+		"the other sources should not be affected")
+}
+
+func TestStreamSchedule(t *testing.T) {
+	checkGoroutines(t)
+	tests := map[string]struct {
+		opts Options
+		want []string
+	}{
+		"round-robin": {
+			opts: Options{Schedule: ScheduleRoundRobin},
+			want: []string{"t3_a_1", "t3_b_1", "t3_a_2", "t3_b_2", "t3_a_3", "t3_b_3", "t3_a_4", "t3_b_4"},
+		},
+		"weighted": {
+			opts: Options{Schedule: ScheduleWeighted, Weights: map[string]int{"r/a": 3}},
+			want: []string{"t3_a_1", "t3_a_2", "t3_a_3", "t3_b_1", "t3_a_4", "t3_b_2", "t3_b_3", "t3_b_4"},
+		},
+	}
+	client := listingServer(t, 2)
+	for name, tt := range tests {
+		tt.opts.Subreddits = []string{"a", "b"}
+		s, err := New(client, tt.opts, 4)
+		assert.NoError(t, err)
+
+		var names []string
+		for res := range s.Start(context.Background()) {
+			names = append(names, res.Post.Data.Name)
+		}
+		assert.Equal(t, tt.want, names, name)
+	}
+}
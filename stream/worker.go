@@ -3,82 +3,131 @@ package stream
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/handsomefox/redditdl/api"
 )
 
 var ErrWorkerEOF = errors.New("worker reached the end of it's stream")
 
+// defaultRetryDelay is the delay before refetching a page that failed to be fetched, unless it's set in the options.
+const defaultRetryDelay = 5 * time.Second
+
 type Worker struct {
 	client *api.Client
 	opts   *Options
+	// stop stops the worker, it's set once the worker is started.
+	stop context.CancelFunc
 
-	outCh     chan *api.Post
-	subreddit string
-	after     string
+	source Source
+	// username is the name of the authenticated user, it's requested once for the account sources without a name.
+	username string
+	after    string
+	// cursor is the cursor of the page the current items are from.
+	cursor string
+	// exhausted is set once the last page of the listing was fetched.
+	exhausted bool
 
 	// Store the items here, refetch only if empty
 	currentItems []api.Post
 }
 
-// Run loops over the provided channel, each receive triggers it to send an item to
-// the output channel.
-// When the Run() returns, it means that the worker can no longer fetch any items.
-func (w *Worker) Run(listenCh <-chan struct{}, terminate <-chan struct{}) struct{} {
-	ctx := context.Background()
-
+// Run sends the items of the source to the output channel, fetching the next page once the current one is sent.
+// The fetch errors are sent as well, the page is refetched after a delay, unless the source is unavailable.
+// It returns once the source has no more items, is unavailable, or the context is cancelled.
+func (w *Worker) Run(ctx context.Context, out chan<- *Result) {
 	for {
-		select {
-		case <-listenCh:
-			if len(w.currentItems) == 0 { // if there are no items
-				err := w.fetchItems(ctx) // fetch the items
-				if err != nil {
-					if !errors.Is(err, ErrWorkerEOF) {
-						w.outCh <- nil
-						continue
-					} else {
-						// There are no more items to fetch, report that we're done.
-						return struct{}{}
-					}
+		if len(w.currentItems) == 0 {
+			err := w.fetchItems(ctx)
+			switch {
+			case err == nil:
+			case ctx.Err() != nil:
+				return
+			case errors.Is(err, ErrWorkerEOF):
+				// There are no more items to fetch.
+				return
+			case errors.Is(err, api.ErrNotFound), errors.Is(err, api.ErrForbidden), errors.Is(err, api.ErrUnauthorized):
+				// The subreddit (or user) is banned, private or not accessible, refetching won't help.
+				w.send(ctx, out, &Result{Source: w.source, Cursor: w.after, Err: err})
+				return
+			default:
+				if !w.send(ctx, out, &Result{Source: w.source, Cursor: w.after, Err: err}) {
+					return
+				}
+				select {
+				case <-time.After(w.retryDelay()):
+					continue
+				case <-ctx.Done():
+					return
 				}
 			}
-			// We can yield one item to the stream output.
-			w.outCh <- &w.currentItems[0]
-			w.currentItems = w.currentItems[1:]
-		case <-terminate:
-			return struct{}{}
 		}
+
+		if !w.send(ctx, out, &Result{Post: &w.currentItems[0], Source: w.source, Cursor: w.cursor}) {
+			return
+		}
+		w.currentItems = w.currentItems[1:]
 	}
 }
 
-func (w *Worker) fetchItems(ctx context.Context) error {
-	opts := &api.RequestOptions{
-		After:     w.after,
-		Count:     100,
-		Sorting:   w.opts.Sort,
-		Timeframe: w.opts.Timeframe,
-		Subreddit: w.subreddit,
+// send sends the result to the output channel, and reports whether it was sent before the context was cancelled.
+func (w *Worker) send(ctx context.Context, out chan<- *Result, res *Result) bool {
+	select {
+	case out <- res:
+		return true
+	case <-ctx.Done():
+		return false
 	}
+}
 
-	res, after, err := w.client.Subreddit.GetPosts(ctx, opts)
-	if err != nil {
-		return err
+func (w *Worker) retryDelay() time.Duration {
+	if w.opts.RetryDelay > 0 {
+		return w.opts.RetryDelay
 	}
+	return defaultRetryDelay
+}
 
-	if len(res) == 0 {
-		return ErrWorkerEOF
+func (w *Worker) fetchItems(ctx context.Context) error {
+	source := w.source
+	if source.isAccount() && source.Name == "" {
+		if w.username == "" {
+			name, err := w.client.Username(ctx)
+			if err != nil {
+				return err
+			}
+			w.username = name
+		}
+		source.Name = w.username
 	}
 
-	w.after = after
-	w.currentItems = res
+	// Skip the pages that have nothing of the wanted kind.
+	for !w.exhausted {
+		res, after, err := w.client.Subreddit.GetPosts(ctx, source.requestOptions(w.opts, w.after))
+		if err != nil {
+			return err
+		}
+
+		w.cursor, w.after = w.after, after
+		w.exhausted = len(res) == 0 || after == ""
+		if w.currentItems = w.filter(res); len(w.currentItems) != 0 {
+			return nil
+		}
+	}
 
-	return nil
+	return ErrWorkerEOF
 }
 
-func (w *Worker) tryPerformInitialFetch() {
-	ctx := context.Background()
-	err := w.fetchItems(ctx)
-	if err != nil {
-		// Do nothing,
+// filter returns the items of the wanted kind, for the listings of the authenticated user.
+func (w *Worker) filter(posts []api.Post) []api.Post {
+	// Only the saved and upvoted listings contain comments, the other listings are kept as they are.
+	if w.opts.ItemKind == "" || w.opts.ItemKind == "all" || !w.source.isAccount() {
+		return posts
+	}
+	filtered := posts[:0]
+	for i := range posts {
+		if posts[i].IsComment() == (w.opts.ItemKind == "comments") {
+			filtered = append(filtered, posts[i])
+		}
 	}
+	return filtered
 }
@@ -0,0 +1,71 @@
+package stream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/handsomefox/redditdl/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerFetchItems(t *testing.T) {
+	t.Parallel()
+	pages := map[string]string{
+		"":     `{"data": {"after": "t3_b", "children": [{"kind": "t1", "data": {"name": "t1_a"}}, {"kind": "t3", "data": {"name": "t3_b"}}]}}`,
+		"t3_b": `{"data": {"after": "t1_c", "children": [{"kind": "t1", "data": {"name": "t1_c"}}]}}`,
+		"t1_c": `{"data": {"after": null, "children": [{"kind": "t3", "data": {"name": "t3_d"}}]}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/user/example/saved.json", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(pages[r.URL.Query().Get("after")]))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	w := Worker{
+		client: api.DefaultClient().WithBaseURL(u),
+		opts:   &Options{ItemKind: "links"},
+		source: Source{Kind: SourceSaved, Name: "example"},
+	}
+
+	var names []string
+	for {
+		err := w.fetchItems(context.TODO())
+		if err != nil {
+			assert.ErrorIs(t, err, ErrWorkerEOF)
+			break
+		}
+		for _, p := range w.currentItems {
+			names = append(names, p.Data.Name)
+		}
+	}
+	assert.Equal(t, []string{"t3_b", "t3_d"}, names, "comments should be filtered out, pages without links skipped")
+}
+
+func TestWorkerFilterAccountOnly(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/r/wallpaper/best.json", r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"data": {"after": null, "children": [{"kind": "t3", "data": {"name": "t3_a"}}]}}`))
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	assert.NoError(t, err)
+	w := Worker{
+		client: api.DefaultClient().WithBaseURL(u),
+		opts:   &Options{ItemKind: "comments", Sort: "best"},
+		source: Source{Kind: SourceSubreddit, Name: "wallpaper"},
+	}
+
+	assert.NoError(t, w.fetchItems(context.TODO()))
+	assert.Len(t, w.currentItems, 1, "the kind should only filter the saved and upvoted listings")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/handsomefox/redditdl/api"
+)
+
+// templatePlaceholders are the placeholders supported by the output templates.
+var templatePlaceholders = map[string]bool{
+	"id": true, "name": true, "title": true, "author": true, "subreddit": true,
+	"year": true, "month": true, "day": true, "date": true,
+	"score": true, "width": true, "height": true, "orientation": true, "flair": true, "index": true,
+	"ext": true,
+}
+
+// OutputTemplate is the path of the saved media relative to the output directory,
+// e.g. "{subreddit}/{year}/{month}/{id}_{title}.{ext}".
+type OutputTemplate struct {
+	dirs []string
+	// name is the filename without the extension.
+	name string
+}
+
+// ParseOutputTemplate validates the template, ".{ext}" is appended to it, unless the template ends with it.
+// The segments of the path are separated by forward slashes.
+func ParseOutputTemplate(template string) (*OutputTemplate, error) {
+	if strings.TrimSpace(template) == "" {
+		return nil, errors.New("output template can not be empty")
+	}
+	if strings.HasPrefix(template, "/") || filepath.IsAbs(template) {
+		return nil, fmt.Errorf("output template must be relative to the output directory: %s", template)
+	}
+
+	for rest := template; ; {
+		i := strings.IndexAny(rest, "{}")
+		if i == -1 {
+			break
+		}
+		if rest[i] == '}' {
+			return nil, fmt.Errorf("output template has an unmatched '}': %s", template)
+		}
+		end := strings.IndexByte(rest[i:], '}')
+		if end == -1 {
+			return nil, fmt.Errorf("output template has an unclosed '{': %s", template)
+		}
+		if placeholder := rest[i+1 : i+end]; !templatePlaceholders[placeholder] {
+			return nil, fmt.Errorf("output template has an unknown placeholder {%s}", placeholder)
+		}
+		rest = rest[i+end+1:]
+	}
+
+	segments := strings.Split(strings.TrimSuffix(template, ".{ext}"), "/")
+	for _, segment := range segments {
+		if segment == ".." {
+			return nil, fmt.Errorf("output template must not leave the output directory: %s", template)
+		}
+	}
+	name := segments[len(segments)-1]
+	if strings.TrimSpace(name) == "" {
+		return nil, fmt.Errorf("output template must end with a filename: %s", template)
+	}
+
+	return &OutputTemplate{dirs: segments[:len(segments)-1], name: name}, nil
+}
+
+// Path returns the path of the item of the post, relative to the output directory wd.
+// The values of the placeholders are sanitized, the directory names and the filename are truncated to the allowed length,
+// directories that turn out empty (e.g. posts without flair) are omitted.
+// Name collisions are resolved like in NewFormattedFilename.
+func (t *OutputTemplate) Path(wd string, post *api.Post, item *api.Item) (string, error) {
+	r := templateReplacer(post, item)
+
+	parts := make([]string, 0, len(t.dirs)+1)
+	for _, dir := range t.dirs {
+		dir = formatDirname(strings.TrimSpace(r.Replace(dir)))
+		if dir == "" || dir == "." || dir == ".." {
+			continue
+		}
+		parts = append(parts, dir)
+	}
+
+	name := strings.TrimSpace(r.Replace(t.name))
+	filename, err := formatFilename(name, item.Extension)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to create filename from the output template (name=%s)", err, name)
+	}
+	path := filepath.Join(append(parts, filename)...)
+	// Resolve duplicates
+	for i := 0; FileExists(filepath.Join(wd, path)); i++ {
+		filename, err = formatFilename(fmt.Sprintf("(%d) %s", i, name), item.Extension)
+		if err != nil {
+			return "", fmt.Errorf("%w: failed to create filename from the output template (name=%s)", err, name)
+		}
+		path = filepath.Join(append(parts, filename)...)
+	}
+
+	return path, nil
+}
+
+func templateReplacer(post *api.Post, item *api.Item) *strings.Replacer {
+	created := post.Created()
+	values := map[string]string{
+		"id":          post.ID(),
+		"name":        item.Name,
+		"title":       post.Title(),
+		"author":      post.Author(),
+		"subreddit":   post.Subreddit(),
+		"year":        created.Format("2006"),
+		"month":       created.Format("01"),
+		"day":         created.Format("02"),
+		"date":        created.Format(time.DateOnly),
+		"score":       strconv.Itoa(post.Score()),
+		"width":       strconv.Itoa(item.Width),
+		"height":      strconv.Itoa(item.Height),
+		"orientation": item.Orientation,
+		"flair":       post.Flair(),
+		"index":       strconv.Itoa(item.Index),
+		"ext":         item.Extension,
+	}
+
+	oldnew := make([]string, 0, len(values)*2)
+	for placeholder, value := range values {
+		// The values must not add path segments.
+		oldnew = append(oldnew, "{"+placeholder+"}", removeForbiddenChars(value))
+	}
+	return strings.NewReplacer(oldnew...)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/handsomefox/redditdl/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOutputTemplate(t *testing.T) {
+	t.Parallel()
+	for _, template := range []string{
+		"{subreddit}/{id}",
+		"{subreddit}/{year}/{month}/{id}_{title}.{ext}",
+		"{author}/{date} {score} {width}x{height} {orientation} [{flair}] {index} {name}",
+	} {
+		_, err := ParseOutputTemplate(template)
+		assert.NoError(t, err, template)
+	}
+
+	for _, template := range []string{
+		"",
+		"/absolute/{id}",
+		"../{id}",
+		"{subreddit}/",
+		"{unknown}",
+		"{id",
+		"id}",
+	} {
+		_, err := ParseOutputTemplate(template)
+		assert.Error(t, err, template)
+	}
+}
+
+func TestOutputTemplatePath(t *testing.T) {
+	t.Parallel()
+	var p api.Post
+	p.Data.ID = "11tug3p"
+	p.Data.Title = "A/B: test"
+	p.Data.Author = "artist"
+	p.Data.Subreddit = "wallpaper"
+	p.Data.Score = 42
+	p.Data.CreatedUTC = 1679043600 // 2023-03-17 09:00 UTC
+	item := &api.Item{Name: "image", Extension: "png", Width: 1920, Height: 1080, Orientation: "landscape", Index: 2}
+
+	dir := t.TempDir()
+	template, err := ParseOutputTemplate("{subreddit}/{year}/{month}/{flair}/{id}_{title}")
+	assert.NoError(t, err)
+	path, err := template.Path(dir, &p, item)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("wallpaper", "2023", "03", "11tug3p_AB test.png"), path,
+		"values should be sanitized and empty directories omitted")
+
+	template, err = ParseOutputTemplate("{author}/{date}_{score}_{width}x{height}_{orientation}_{index}.{ext}")
+	assert.NoError(t, err)
+	path, err = template.Path(dir, &p, item)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("artist", "2023-03-17_42_1920x1080_landscape_2.png"), path)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "artist"), os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, path), nil, 0o666))
+	path, err = template.Path(dir, &p, item)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("artist", "(0) 2023-03-17_42_1920x1080_landscape_2.png"), path,
+		"existing files should not be overwritten")
+
+	template, err = ParseOutputTemplate("{flair}")
+	assert.NoError(t, err)
+	_, err = template.Path(dir, &p, item)
+	assert.Error(t, err, "empty filename should not be accepted")
+
+	// The directories are limited like the filenames, so they can be created.
+	p.Data.Title = strings.Repeat("long title ", 40)
+	template, err = ParseOutputTemplate("{title}/{name}")
+	assert.NoError(t, err)
+	path, err = template.Path(dir, &p, item)
+	assert.NoError(t, err)
+	assert.Len(t, filepath.Dir(path), MaxFilenameLength, "directory should be truncated")
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(path)), os.ModePerm))
+
+	// Multi-byte characters are not cut in half.
+	p.Data.Title = strings.Repeat("ä", 200)
+	path, err = template.Path(dir, &p, item)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Repeat("ä", MaxFilenameLength/2), filepath.Dir(path), "directory should be truncated at a rune boundary")
+	assert.True(t, utf8.ValidString(path))
+}